@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/maxexcloo/tofusort/internal/cache"
+	"github.com/maxexcloo/tofusort/internal/parser"
+	"github.com/maxexcloo/tofusort/internal/sorter"
+	"github.com/spf13/cobra"
+)
+
+var noCache bool
+
+// fileCache records sorted file contents across runs. It is nil when caching
+// is disabled or unavailable.
+var fileCache *cache.Cache
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache of files known to be sorted",
+	Long: `sort and check record the contents of files that are already sorted, keyed
+by the tofusort version and configuration, and skip those files on later runs.`,
+}
+
+var cacheCleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove all cache entries",
+	Args:  cobra.NoArgs,
+	RunE:  runCacheClean,
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheCleanCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+func runCacheClean(cmd *cobra.Command, args []string) error {
+	dir, err := cache.Dir()
+	if err != nil {
+		return err
+	}
+	if err := cache.Clean(dir); err != nil {
+		return err
+	}
+	fmt.Printf("Removed cache: %s\n", dir)
+	return nil
+}
+
+// newCache opens the cache for the parser's and sorter's configuration. The
+// cache only saves work, so it is disabled rather than failing when it cannot
+// be used.
+func newCache(p *parser.Parser, s *sorter.Sorter) *cache.Cache {
+	if noCache {
+		return nil
+	}
+
+	dir, err := cache.Dir()
+	if err != nil {
+		return nil
+	}
+	version, err := buildVersion()
+	if err != nil {
+		return nil
+	}
+	config, err := json.Marshal(s.Config())
+	if err != nil {
+		return nil
+	}
+	return cache.New(dir, version, config, []byte(p.LineEndings))
+}
+
+// buildVersion identifies the running build: its module version for releases,
+// and otherwise a hash of the executable so that rebuilt development binaries
+// do not reuse each other's entries
+func buildVersion() (string, error) {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version, nil
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// cachedSorted reports whether content is recorded as sorted
+func cachedSorted(content []byte) bool {
+	return fileCache != nil && fileCache.Sorted(content)
+}
+
+// cacheResult records content as sorted when sorting left it unchanged without
+// warnings, which would otherwise not be repeated on later runs
+func cacheResult(content, newContent []byte, diags hcl.Diagnostics) {
+	if fileCache != nil && len(diags) == 0 && bytes.Equal(content, newContent) {
+		_ = fileCache.MarkSorted(content)
+	}
+}
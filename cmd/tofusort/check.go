@@ -1,45 +1,77 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"io/fs"
 	"os"
-	"path/filepath"
+	"sort"
+	"time"
 
+	"github.com/hashicorp/hcl/v2"
+	"github.com/maxexcloo/tofusort/internal/analysis"
+	"github.com/maxexcloo/tofusort/internal/diff"
 	"github.com/maxexcloo/tofusort/internal/parser"
 	"github.com/maxexcloo/tofusort/internal/sorter"
 	"github.com/spf13/cobra"
 )
 
+var (
+	failOnDuplicates bool
+	checkIdempotent  bool
+)
+
 var checkCmd = &cobra.Command{
 	Use:   "check [file or directory]",
 	Short: "Check if OpenTofu/Terraform files are sorted",
 	Long: `Check if OpenTofu/Terraform configuration files are already sorted.
-Returns exit code 0 if all files are sorted, 1 if any files need sorting.
-Useful for CI/CD pipelines to enforce sorted configuration files.`,
+Returns exit code 0 if all files are sorted, 1 if any files need sorting, 2 if
+any files cannot be parsed and 3 for I/O errors.
+Useful for CI/CD pipelines to enforce sorted configuration files.
+
+Duplicate object keys, block addresses, locals and required_providers entries
+across the files of each directory are printed with the results, and fail the
+check with --fail-on-duplicates.
+
+With --idempotent, each file is also sorted a second time, and the check fails
+with a diff of the two passes if the second pass changes anything.`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: runCheck,
 }
 
 func init() {
 	checkCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Process directories recursively")
+	checkCmd.Flags().BoolVar(&noCache, "no-cache", false, "Check every file, ignoring and not updating the cache")
+	checkCmd.Flags().BoolVar(&includeGenerated, "include-generated", false, "Check generated files and vendored modules, which are skipped by default")
+	checkCmd.Flags().BoolVar(&includeMarkdown, "markdown", false, "Check HCL code blocks in Markdown files")
+	checkCmd.Flags().BoolVar(&failOnDuplicates, "fail-on-duplicates", false, "Fail when duplicate definitions are found")
+	checkCmd.Flags().BoolVar(&checkIdempotent, "idempotent", false, "Fail when sorting a file a second time changes it")
 	rootCmd.AddCommand(checkCmd)
 }
 
 func runCheck(cmd *cobra.Command, args []string) error {
-	p := parser.New()
-	s := sorter.New()
+	p, err := newParser()
+	if err != nil {
+		return err
+	}
+	s, err := newSorter()
+	if err != nil {
+		return err
+	}
+	fileCache = newCache(p, s)
+	startSummary()
 
+	var checkedFiles []string
 	var unsortedFiles []string
 	var errs []error
 
 	for _, path := range args {
-		files, err := checkPath(path, p, s)
+		checked, unsorted, err := checkPath(path, p, s)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("failed to check %s: %w", path, err))
 		}
-		unsortedFiles = append(unsortedFiles, files...)
+		checkedFiles = append(checkedFiles, checked...)
+		unsortedFiles = append(unsortedFiles, unsorted...)
 	}
 
 	if len(unsortedFiles) > 0 {
@@ -48,118 +80,185 @@ func runCheck(cmd *cobra.Command, args []string) error {
 		}
 		errs = append(
 			errs,
-			fmt.Errorf(
+			unsortedError(
 				"found %d unsorted file(s); run 'tofusort sort' to fix",
 				len(unsortedFiles),
 			),
 		)
 	}
 
+	if duplicates := reportDuplicates(checkedFiles); duplicates > 0 && failOnDuplicates {
+		errs = append(errs, unsortedError("found %d duplicate definition(s)", duplicates))
+	}
+
 	if len(errs) == 0 {
 		fmt.Println("All files are sorted!")
 	}
+	printSummary()
 	return errors.Join(errs...)
 }
 
-func checkPath(path string, p *parser.Parser, s *sorter.Sorter) ([]string, error) {
+// checkPath checks a file or directory, returning the files checked and those
+// that are not sorted
+func checkPath(path string, p *parser.Parser, s *sorter.Sorter) ([]string, []string, error) {
 	info, err := os.Stat(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to stat path: %w", err)
+		return nil, nil, ioError("failed to stat path: %w", err)
 	}
 
 	if info.IsDir() {
 		return checkDirectory(path, p, s)
 	}
 
+	if !isSourceFile(path) {
+		return nil, nil, nil
+	}
+
 	unsorted, err := checkFile(path, p, s)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if unsorted {
-		return []string{path}, nil
+		return []string{path}, []string{path}, nil
 	}
 
-	return nil, nil
+	return []string{path}, nil, nil
 }
 
-func checkDirectory(dir string, p *parser.Parser, s *sorter.Sorter) ([]string, error) {
-	var unsortedFiles []string
-	var errs []error
+func checkDirectory(dir string, p *parser.Parser, s *sorter.Sorter) ([]string, []string, error) {
+	files, err := discoverFiles(dir)
+	errs := []error{err}
 
-	if recursive {
-		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				errs = append(errs, fmt.Errorf("failed to access %s: %w", path, err))
-				return nil
-			}
+	var unsortedFiles []string
+	for _, path := range files {
+		unsorted, err := checkFile(path, p, s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to check %s: %w", path, err))
+			continue
+		}
+		if unsorted {
+			unsortedFiles = append(unsortedFiles, path)
+		}
+	}
 
-			if d.IsDir() {
-				return nil
-			}
+	return files, unsortedFiles, errors.Join(errs...)
+}
 
-			if isTerraformFile(path) {
-				unsorted, err := checkFile(path, p, s)
-				if err != nil {
-					errs = append(errs, fmt.Errorf("failed to check %s: %w", path, err))
-					return nil
-				}
-				if unsorted {
-					unsortedFiles = append(unsortedFiles, path)
-				}
-			}
+// checkFile reports whether a file is not sorted. Errors are returned as
+// *codedError.
+func checkFile(path string, p *parser.Parser, s *sorter.Sorter) (unsorted bool, err error) {
+	if !isSourceFile(path) {
+		return false, nil
+	}
 
-			return nil
-		})
-		if err != nil {
-			errs = append(errs, err)
+	summary.scanned++
+	result := "sorted"
+	start := time.Now()
+	defer func() {
+		switch {
+		case err != nil:
+			summary.errored++
+		case unsorted:
+			summary.unsorted++
+			result = "unsorted"
 		}
-		return unsortedFiles, errors.Join(errs...)
-	}
+		logFile(path, result, start, err)
+	}()
 
-	entries, err := os.ReadDir(dir)
+	content, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read directory: %w", err)
+		return false, ioError("failed to read file: %w", err)
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
+	if skipGenerated(path, content) {
+		result = "skipped"
+		return false, nil
+	}
+	if cachedSorted(content) {
+		result = "cached"
+		return false, nil
+	}
+	if isMarkdownFile(path) {
+		return checkMarkdown(path, content, p, s)
+	}
 
-		path := filepath.Join(dir, entry.Name())
-		if isTerraformFile(path) {
-			unsorted, err := checkFile(path, p, s)
-			if err != nil {
-				errs = append(errs, fmt.Errorf("failed to check %s: %w", path, err))
-				continue
-			}
-			if unsorted {
-				unsortedFiles = append(unsortedFiles, path)
-			}
+	layout := parser.DetectLayout(content)
+	file, err := p.ParseFile(content, path)
+	if err != nil {
+		return false, reportParseError(err)
+	}
+
+	defer logRules(s, path)()
+	diags := s.SortFile(file)
+	logDiagnostics(path, diags)
+	formatted := p.FormatFile(file)
+	if checkIdempotent {
+		if err := s.CheckIdempotent(p, formatted, path); err != nil {
+			return false, idempotenceError(path, err)
 		}
 	}
+	newContent := p.Restore(formatted, layout)
+	cacheResult(content, newContent, diags)
 
-	return unsortedFiles, errors.Join(errs...)
+	return differs(p, content, newContent), nil
 }
 
-func checkFile(path string, p *parser.Parser, s *sorter.Sorter) (bool, error) {
-	if !isTerraformFile(path) {
-		return false, nil
+// idempotenceError prints the diff between the two passes of a file that
+// changes when sorted again, and returns err as a check failure
+func idempotenceError(path string, err error) error {
+	var notIdempotent *sorter.NotIdempotentError
+	if errors.As(err, &notIdempotent) {
+		os.Stdout.Write(diff.Unified(path+" (sorted once)", path+" (sorted twice)", notIdempotent.First, notIdempotent.Second))
 	}
+	return &codedError{code: exitUnsorted, err: err}
+}
 
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return false, fmt.Errorf("failed to read file: %w", err)
+// differs reports whether sorting changed a file. Line endings and byte-order
+// marks only count when --line-endings asks for specific line endings.
+func differs(p *parser.Parser, content, newContent []byte) bool {
+	if p.LineEndings == parser.LineEndingsAuto {
+		return !bytes.Equal(parser.Normalize(content), parser.Normalize(newContent))
 	}
+	return !bytes.Equal(content, newContent)
+}
 
-	file, err := p.ParseFile(content)
-	if err != nil {
-		return false, fmt.Errorf("failed to parse file: %w", err)
+// reportDuplicates prints the duplicate definitions found across the files of
+// each directory and returns how many were found
+func reportDuplicates(files []string) int {
+	groups := groupByDirectory(files)
+	dirs := make([]string, 0, len(groups))
+	for dir := range groups {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	count := 0
+	for _, dir := range dirs {
+		contents := make(map[string][]byte, len(groups[dir]))
+		for _, path := range groups[dir] {
+			if !isTerraformFile(path) {
+				continue
+			}
+			// Unreadable files are already reported by checkFile
+			if content, err := os.ReadFile(path); err == nil {
+				contents[path] = parser.Normalize(content)
+			}
+		}
+
+		diags := analysis.Duplicates(contents)
+		printFindings(dir, diags)
+		count += len(diags)
 	}
 
-	s.SortFile(file)
-	newContent := p.FormatFile(file)
+	return count
+}
 
-	return string(content) != string(newContent), nil
+// printFindings prints the problems check reports alongside unsorted files,
+// such as duplicate definitions and code blocks that do not parse, located by
+// diagnosticLocation. They are results, so --quiet does not hide them.
+func printFindings(path string, diags hcl.Diagnostics) {
+	for _, diag := range diags {
+		fmt.Printf("%s: %s: %s\n", diag.Summary, diagnosticLocation(path, diag), diag.Detail)
+	}
 }
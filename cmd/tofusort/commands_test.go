@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/maxexcloo/tofusort/internal/parser"
 	"github.com/maxexcloo/tofusort/internal/sorter"
 )
@@ -63,3 +69,565 @@ func TestRunSortReportsAllInputErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestRunCheckFailOnDuplicates(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	directory := t.TempDir()
+	for _, name := range []string{"a.tf", "b.tf"} {
+		content := "resource \"aws_instance\" \"web\" {\n  ami = \"ami-12345\"\n}\n"
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recursive = false
+	failOnDuplicates = false
+	if err := runCheck(nil, []string{directory}); err != nil {
+		t.Fatalf("runCheck() error = %v, want duplicates reported as warnings", err)
+	}
+
+	failOnDuplicates = true
+	quiet = true
+	defer func() { failOnDuplicates, quiet = false, false }()
+	var err error
+	output := captureStdout(t, func() { err = runCheck(nil, []string{directory}) })
+	if err == nil || !strings.Contains(err.Error(), "1 duplicate definition") {
+		t.Errorf("runCheck() error = %v, want duplicate definition error", err)
+	}
+	expected := "Duplicate resource block: " + filepath.Join(directory, "b.tf") + ":1,1-30: aws_instance.web was already defined at " + filepath.Join(directory, "a.tf")
+	if !strings.Contains(output, expected) {
+		t.Errorf("runCheck() with --quiet printed:\n%s\nwant %s", output, expected)
+	}
+}
+
+func TestExplainFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.tf")
+	content := "resource \"aws_instance\" \"web\" {\n  ami = \"ami-12345\"\n}\n\n\n\ndata \"aws_ami\" \"ubuntu\" {\n  most_recent = true\n}\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	if err := explainFile(path, parser.New(), sorter.New(), &output); err != nil {
+		t.Fatalf("explainFile() error = %v", err)
+	}
+
+	expected := path + ":7 -> 1: block data.aws_ami.ubuntu: block-type order: data first [block-order]\n" +
+		path + ":8 -> 2: attribute data.aws_ami.ubuntu.most_recent: single-line attribute group [attribute-order]\n" +
+		path + ":1 -> 5: block aws_instance.web: block-type order: data before resource [block-order]\n" +
+		path + ":2 -> 6: attribute aws_instance.web.ami: single-line attribute group [attribute-order]\n"
+	if output.String() != expected {
+		t.Errorf("explainFile() output:\n%s\nwant:\n%s", output.String(), expected)
+	}
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != content {
+		t.Error("explainFile() modified the file")
+	}
+}
+
+func TestLineEndingsAndByteOrderMark(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.tf")
+	content := "\xef\xbb\xbfz = 1\r\na = 2\r\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dryRun = false
+	if err := processFile(path, parser.New(), sorter.New()); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != "\xef\xbb\xbfa = 2\r\nz = 1\r\n" {
+		t.Errorf("processFile() wrote %q, want byte-order mark and CRLF kept", written)
+	}
+
+	// A sorted file with mixed line endings only fails check when specific
+	// line endings are requested
+	if err := os.WriteFile(path, []byte("a = 2\r\nz = 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if unsorted, err := checkFile(path, parser.New(), sorter.New()); err != nil || unsorted {
+		t.Errorf("checkFile() = %t, %v, want sorted", unsorted, err)
+	}
+	p, err := parser.NewWithLineEndings(parser.LineEndingsLF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unsorted, err := checkFile(path, p, sorter.New()); err != nil || !unsorted {
+		t.Errorf("checkFile() with LF line endings = %t, %v, want unsorted", unsorted, err)
+	}
+}
+
+func TestRunCheckCachesSortedFiles(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	directory := t.TempDir()
+	sorted := []byte("a = 1\nz = 2\n")
+	unsorted := []byte("z = 2\na = 1\n")
+	if err := os.WriteFile(filepath.Join(directory, "sorted.tf"), sorted, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, "unsorted.tf"), unsorted, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	recursive = false
+	noCache = true
+	err := runCheck(nil, []string{directory})
+	noCache = false
+	if err == nil {
+		t.Fatal("runCheck() returned nil for an unsorted file")
+	}
+	if fileCache != nil {
+		t.Fatal("runCheck() opened the cache with --no-cache")
+	}
+
+	_ = runCheck(nil, []string{directory})
+	if fileCache == nil {
+		t.Fatal("runCheck() did not open the cache")
+	}
+	if !fileCache.Sorted(sorted) {
+		t.Error("sorted file was not cached")
+	}
+	if fileCache.Sorted(unsorted) {
+		t.Error("unsorted file was cached")
+	}
+	fileCache = nil
+}
+
+func TestRunCheckIdempotent(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "main.tf")
+	content := "resource \"aws_instance\" \"web\" { # web\n  tags = {}\n  ami  = \"ami-12345\"\n}\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	checkIdempotent = true
+	defer func() { checkIdempotent = false }()
+	if unsorted, err := checkFile(path, parser.New(), sorter.New()); err != nil || !unsorted {
+		t.Errorf("checkFile() with --idempotent = %t, %v, want unsorted", unsorted, err)
+	}
+}
+
+func TestIsGenerated(t *testing.T) {
+	generatedMarkers = []string{"@generated"}
+	defer func() { generatedMarkers = nil }()
+
+	tests := map[string]bool{
+		"# Code generated by terraform-docs; DO NOT EDIT.\na = 1\n":     true,
+		"\xef\xbb\xbf// Code generated by cdktf. DO NOT EDIT.\na = 1\n": true,
+		"# Copyright\n\n# @generated\na = 1\n":                          true,
+		"a = 1\n# Code generated by hand; DO NOT EDIT.\n":               false,
+		"# Code generated by hand\na = 1\n":                             false,
+	}
+	for content, expected := range tests {
+		if actual := isGenerated([]byte(content)); actual != expected {
+			t.Errorf("isGenerated(%q) = %t, want %t", content, actual, expected)
+		}
+	}
+}
+
+func TestSkipGeneratedAndVendoredFiles(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	directory := t.TempDir()
+	generated := "# Code generated by tool; DO NOT EDIT.\nz = 1\na = 2\n"
+	files := map[string]string{
+		"main.tf":                         "z = 1\na = 2\n",
+		"generated.tf":                    generated,
+		".terraform/modules/vpc/main.tf":  "z = 1\na = 2\n",
+		"vendor/dns/main.tf":              "z = 1\na = 2\n",
+		"modules/local/main.tf":           "z = 1\na = 2\n",
+		".terraform/modules/modules.json": `{"Modules":[{"Key":"","Source":"","Dir":"."},{"Key":"dns","Source":"git::https://example.com/dns.git","Dir":"vendor/dns"},{"Key":"local","Source":"./modules/local","Dir":"modules/local"}]}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(directory, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recursive = true
+	defer func() { recursive = false }()
+	startSummary()
+	discovered, err := discoverFiles(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, path := range discovered {
+		name, _ := filepath.Rel(directory, path)
+		names = append(names, filepath.ToSlash(name))
+	}
+	if strings.Join(names, ",") != "generated.tf,main.tf,modules/local/main.tf" {
+		t.Errorf("discoverFiles() = %v, want vendored modules skipped", names)
+	}
+
+	dryRun = false
+	path := filepath.Join(directory, "generated.tf")
+	if err := processFile(path, parser.New(), sorter.New()); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != generated {
+		t.Errorf("processFile() modified a generated file:\n%s", content)
+	}
+	if summary.skipped != 1 {
+		t.Errorf("summary counts %d skipped, want only the generated file", summary.skipped)
+	}
+
+	includeGenerated = true
+	defer func() { includeGenerated = false }()
+	if discovered, _ = discoverFiles(directory); len(discovered) != 5 {
+		t.Errorf("discoverFiles() with --include-generated = %v, want all 5 files", discovered)
+	}
+	if err := processFile(path, parser.New(), sorter.New()); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(path); string(content) == generated {
+		t.Error("processFile() with --include-generated skipped a generated file")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"nil", nil, 0},
+		{"uncoded", errors.New("unknown flag"), exitError},
+		{"unsorted", unsortedError("found 1 unsorted file(s)"), exitUnsorted},
+		{"wrapped", fmt.Errorf("failed to check main.tf: %w", parseError(errors.New("syntax"))), exitParse},
+		{"joined", errors.Join(unsortedError("unsorted"), parseError(errors.New("syntax"))), exitParse},
+		{"joined with uncoded", errors.Join(unsortedError("unsorted"), errors.New("stat")), exitError},
+	}
+	for _, test := range tests {
+		if actual := exitCode(test.err); actual != test.expected {
+			t.Errorf("exitCode() for %s = %d, want %d", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestUnreportedErrors(t *testing.T) {
+	t.Parallel()
+
+	reported := &codedError{code: exitParse, err: errors.New("failed to parse file: syntax"), reported: true}
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"reported", reported, ""},
+		{"wrapped", fmt.Errorf("failed to process main.tf: %w", reported), ""},
+		{"joined", errors.Join(unsortedError("found 1 unsorted file(s)"), reported), "found 1 unsorted file(s)"},
+		{
+			"wrapped join",
+			fmt.Errorf("failed to process dir: %w", errors.Join(reported, ioError("failed to read file"))),
+			"failed to process dir: failed to read file",
+		},
+	}
+	for _, test := range tests {
+		actual := ""
+		if err := unreportedErrors(test.err); err != nil {
+			actual = err.Error()
+		}
+		if actual != test.expected {
+			t.Errorf("unreportedErrors() for %s = %q, want %q", test.name, actual, test.expected)
+		}
+	}
+	if code := exitCode(fmt.Errorf("failed to process main.tf: %w", reported)); code != exitParse {
+		t.Errorf("exitCode() for a reported parse error = %d, want %d", code, exitParse)
+	}
+}
+
+func TestRunExitCodesAndSummary(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	directory := t.TempDir()
+	files := map[string]string{
+		"invalid.tf":      "invalid {",
+		"sorted.tfvars":   "a = 1\nb = 2\n",
+		"unsorted.tfvars": "b = 2\na = 1\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(directory, name) }
+
+	recursive = false
+	dryRun = true
+	quiet = true
+	defer func() { dryRun = false; quiet = false }()
+
+	err := runCheck(nil, []string{path("sorted.tfvars"), path("unsorted.tfvars")})
+	if code := exitCode(err); code != exitUnsorted {
+		t.Errorf("runCheck() with an unsorted file exit code = %d, want %d: %v", code, exitUnsorted, err)
+	}
+	if summary.scanned != 2 || summary.unsorted != 1 || summary.errored != 0 {
+		t.Errorf("runCheck() summary = %+v, want 2 scanned and 1 unsorted", summary)
+	}
+
+	err = runCheck(nil, []string{directory})
+	if code := exitCode(err); code != exitParse {
+		t.Errorf("runCheck() with an invalid file exit code = %d, want %d: %v", code, exitParse, err)
+	}
+
+	err = runSort(nil, []string{directory})
+	if code := exitCode(err); code != exitParse {
+		t.Errorf("runSort() with an invalid file exit code = %d, want %d: %v", code, exitParse, err)
+	}
+	if summary.scanned != 3 || summary.changed != 0 || summary.unsorted != 1 || summary.errored != 1 {
+		t.Errorf("runSort() with --dry-run summary = %+v, want 3 scanned, 1 unsorted and 1 errored", summary)
+	}
+
+	err = runSort(nil, []string{path("missing.tf")})
+	if code := exitCode(err); code != exitError {
+		t.Errorf("runSort() with a missing file exit code = %d, want %d: %v", code, exitError, err)
+	}
+}
+
+func TestSetupLogger(t *testing.T) {
+	defer func() {
+		verbosity, quiet, logFormat = 0, false, ""
+		logger = slog.New(slog.DiscardHandler)
+	}()
+
+	directory := t.TempDir()
+	files := map[string]string{
+		"gen.tfvars": "# Code generated by hand; DO NOT EDIT.\na = 1\n",
+		"main.tf":    "a = 1\n",
+		"notes.txt":  "a = 1\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		verbosity int
+		quiet     bool
+		expected  []string
+	}{
+		{0, false, nil},
+		{1, false, []string{`"msg":"skipped","path":"` + filepath.Join(directory, "gen.tfvars"), `"msg":"file unchanged"`}},
+		{2, false, []string{`"msg":"skipped file"`, `"msg":"file unchanged"`, `"rule":"attribute-order"`}},
+		{0, true, nil},
+	}
+	for _, test := range tests {
+		var output bytes.Buffer
+		verbosity, quiet, logFormat = test.verbosity, test.quiet, logFormatJSON
+		if err := setupLogger(&output); err != nil {
+			t.Fatal(err)
+		}
+
+		fileCache = nil
+		recursive = false
+		dryRun = true
+		err := processDirectory(directory, parser.New(), sorter.New())
+		dryRun = false
+		if err != nil {
+			t.Fatalf("processDirectory() error = %v", err)
+		}
+
+		lines := strings.Count(output.String(), "\n")
+		if len(test.expected) == 0 && lines > 0 {
+			t.Errorf("-v count %d, quiet %t logged:\n%s", test.verbosity, test.quiet, output.String())
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(output.String(), expected) {
+				t.Errorf("-v count %d logged:\n%s\nwant %s", test.verbosity, output.String(), expected)
+			}
+		}
+	}
+
+	logFormat = "xml"
+	if err := setupLogger(io.Discard); err == nil {
+		t.Error("setupLogger() with an invalid format returned nil")
+	}
+}
+
+func TestLogDiagnostics(t *testing.T) {
+	defer func() {
+		quiet, logFormat = false, ""
+		logger = slog.New(slog.DiscardHandler)
+	}()
+
+	diags := hcl.Diagnostics{{
+		Severity: hcl.DiagWarning,
+		Summary:  "Duplicate object key",
+		Detail:   `The key "a" is also defined at main.tf:1,3-4.`,
+		Subject:  &hcl.Range{Start: hcl.Pos{Line: 2, Column: 3}, End: hcl.Pos{Line: 2, Column: 4}},
+	}}
+	for _, quietFlag := range []bool{false, true} {
+		var output bytes.Buffer
+		quiet, logFormat = quietFlag, logFormatJSON
+		if err := setupLogger(&output); err != nil {
+			t.Fatal(err)
+		}
+
+		logDiagnostics("main.tf", diags)
+		expected := ""
+		if !quietFlag {
+			expected = `"level":"WARN","msg":"Duplicate object key","location":"main.tf:2,3-4"`
+		}
+		if expected == "" && output.Len() > 0 || !strings.Contains(output.String(), expected) {
+			t.Errorf("logDiagnostics() with quiet %t logged:\n%s\nwant %s", quietFlag, output.String(), expected)
+		}
+	}
+}
+
+func TestOutputPatchAndOutDir(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	files := map[string]string{
+		"gen.tfvars":             "# Code generated by hand; DO NOT EDIT.\nb = 2\na = 1\n",
+		"main.tf":                "a = 1\n",
+		"modules/vpc/vpc.tfvars": "b = 2\na = 1\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() { recursive, outputPatch, outDir = false, "", "" }()
+	recursive = true
+
+	outputPatch = "changes.patch"
+	if err := runSort(nil, []string{"."}); err != nil {
+		t.Fatalf("runSort() with --output-patch error = %v", err)
+	}
+	written, err := os.ReadFile("changes.patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "diff --git a/modules/vpc/vpc.tfvars b/modules/vpc/vpc.tfvars\n" +
+		"--- a/modules/vpc/vpc.tfvars\n" +
+		"+++ b/modules/vpc/vpc.tfvars\n" +
+		"@@ -1,2 +1,2 @@\n" +
+		"-b = 2\n" +
+		" a = 1\n" +
+		"+b = 2\n"
+	if string(written) != expected {
+		t.Errorf("--output-patch wrote:\n%s\nwant:\n%s", written, expected)
+	}
+
+	outputPatch = ""
+	outDir = "sorted"
+	for range 2 {
+		if err := runSort(nil, []string{"."}); err != nil {
+			t.Fatalf("runSort() with --out-dir error = %v", err)
+		}
+	}
+	for name, content := range files {
+		original, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(original) != content {
+			t.Errorf("--out-dir modified %s:\n%s", name, original)
+		}
+	}
+	copied, err := os.ReadFile(filepath.Join("sorted", "modules", "vpc", "vpc.tfvars"))
+	if err != nil || string(copied) != "a = 1\nb = 2\n" {
+		t.Errorf("--out-dir wrote %q, %v, want sorted copy", copied, err)
+	}
+	for _, name := range []string{"main.tf", "gen.tfvars"} {
+		copied, err := os.ReadFile(filepath.Join("sorted", name))
+		if err != nil || string(copied) != files[name] {
+			t.Errorf("--out-dir wrote %s as %q, %v, want unchanged copy", name, copied, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("sorted", "sorted")); err == nil {
+		t.Error("--out-dir sorted its own output")
+	}
+
+	if err := os.WriteFile("invalid.tf", []byte("invalid {"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSort(nil, []string{"invalid.tf"}); exitCode(err) != exitParse {
+		t.Errorf("runSort() with --out-dir and an invalid file error = %v, want parse error", err)
+	}
+	if copied, err := os.ReadFile(filepath.Join("sorted", "invalid.tf")); err != nil || string(copied) != "invalid {" {
+		t.Errorf("--out-dir wrote invalid.tf as %q, %v, want unchanged copy", copied, err)
+	}
+}
+
+func TestMarkdownFiles(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	directory := t.TempDir()
+	path := filepath.Join(directory, "README.md")
+	content := "# Usage\n\n```hcl\nb = 1\na = 2\n```\n\n```sh\nb=1\na=2\n```\n\n```tf\nresource \"x\" \"y\" {\n  ...\n}\n```\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { markdownEnabled = false }()
+
+	recursive = false
+	if files, _ := discoverFiles(directory); len(files) != 0 {
+		t.Errorf("discoverFiles() without --markdown = %v, want none", files)
+	}
+
+	markdownEnabled = true
+	quiet = true
+	defer func() { quiet = false }()
+	p, s := parser.New(), sorter.New()
+	output := captureStdout(t, func() {
+		if unsorted, err := checkFile(path, p, s); !unsorted || err != nil {
+			t.Errorf("checkFile() = %t, %v, want unsorted", unsorted, err)
+		}
+	})
+	if expected := "Skipped code block: " + path + ":13,1-1: "; !strings.Contains(output, expected) {
+		t.Errorf("checkFile() with --quiet printed:\n%s\nwant %s", output, expected)
+	}
+	if err := processDirectory(directory, p, s); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Replace(content, "b = 1\na = 2\n", "a = 2\nb = 1\n", 1)
+	if string(written) != expected {
+		t.Errorf("processDirectory() wrote:\n%s\nwant:\n%s", written, expected)
+	}
+	if unsorted, err := checkFile(path, p, s); unsorted || err != nil {
+		t.Errorf("checkFile() after sorting = %t, %v, want sorted", unsorted, err)
+	}
+}
+
+// captureStdout returns what fn writes to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	stdout := os.Stdout
+	os.Stdout = file
+	defer func() { os.Stdout = stdout }()
+	fn()
+
+	output, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output)
+}
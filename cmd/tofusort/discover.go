@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var includeGenerated bool
+
+// discoverFiles returns the OpenTofu/Terraform files in a directory,
+// descending into subdirectories when recursive is set. Modules installed
+// under .terraform/modules or listed in a modules.json manifest are skipped
+// unless includeGenerated is set. Entries that cannot be accessed are reported
+// in the returned error alongside the files found.
+func discoverFiles(dir string) ([]string, error) {
+	var files []string
+	var errs []error
+
+	if recursive {
+		// vendored maps the directories of remote modules listed in the
+		// manifests found so far to the reason they are skipped
+		vendored := make(map[string]string)
+		walkErr := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to access %s: %w", path, err))
+				return nil
+			}
+
+			if d.IsDir() {
+				if reason, skip := vendored[path]; skip && !includeGenerated {
+					reportSkipped(path, reason)
+					return filepath.SkipDir
+				}
+				if path != dir && isOutDir(path) {
+					reportSkipped(path, "output directory")
+					return filepath.SkipDir
+				}
+				if d.Name() == "modules" && filepath.Base(filepath.Dir(path)) == ".terraform" && !includeGenerated {
+					reportSkipped(path, "vendored modules")
+					return filepath.SkipDir
+				}
+				for _, module := range vendoredModules(path) {
+					vendored[module] = "vendored module listed in " + modulesManifest(path)
+				}
+			} else if isSourceFile(path) {
+				files = append(files, path)
+			} else {
+				logger.Debug("skipped file", "path", path, "reason", "unsupported extension")
+			}
+
+			return nil
+		})
+		if walkErr != nil {
+			errs = append(errs, walkErr)
+		}
+		return files, errors.Join(errs...)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			logger.Debug("skipped directory", "path", path, "reason", "not recursive")
+			continue
+		}
+
+		if isSourceFile(path) {
+			files = append(files, path)
+		} else {
+			logger.Debug("skipped file", "path", path, "reason", "unsupported extension")
+		}
+	}
+
+	return files, nil
+}
+
+// groupByDirectory groups files by the module directory containing them
+func groupByDirectory(files []string) map[string][]string {
+	groups := make(map[string][]string)
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		groups[dir] = append(groups[dir], file)
+	}
+	return groups
+}
+
+func isTerraformFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".tf" || ext == ".tfvars"
+}
+
+// modulesManifest returns the path of the manifest listing the modules
+// installed for a root module directory
+func modulesManifest(dir string) string {
+	return filepath.Join(dir, ".terraform", "modules", "modules.json")
+}
+
+// vendoredModules returns the directories of the remote modules listed in the
+// modules.json manifest of a root module directory, if it has one. Modules
+// with local sources are the directory's own code and are not listed.
+func vendoredModules(dir string) []string {
+	content, err := os.ReadFile(modulesManifest(dir))
+	if err != nil {
+		return nil
+	}
+
+	var manifest struct {
+		Modules []struct {
+			Key    string
+			Source string
+			Dir    string
+		}
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil
+	}
+
+	var dirs []string
+	for _, module := range manifest.Modules {
+		local := strings.HasPrefix(module.Source, "./") || strings.HasPrefix(module.Source, "../")
+		if module.Key == "" || module.Dir == "" || local {
+			continue
+		}
+		dirs = append(dirs, filepath.Join(dir, module.Dir))
+	}
+	return dirs
+}
+
+// generatedHeader matches the conventional comment marking generated files,
+// such as "# Code generated by tool; DO NOT EDIT."
+var generatedHeader = regexp.MustCompile(`^(#|//)\s*Code generated .* DO NOT EDIT\.?$`)
+
+// generatedMarkers lists further text marking files as generated, read from
+// the configuration by newSorter
+var generatedMarkers []string
+
+// isGenerated reports whether one of the comment lines at the start of
+// content marks it as generated
+func isGenerated(content []byte) bool {
+	rest := strings.TrimPrefix(string(content), "\ufeff")
+	for rest != "" {
+		var line string
+		line, rest, _ = strings.Cut(rest, "\n")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "//") {
+			return false
+		}
+		if generatedHeader.MatchString(line) {
+			return true
+		}
+		for _, marker := range generatedMarkers {
+			if strings.Contains(line, marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// skipGenerated counts and reports a generated file as skipped and returns
+// true, unless includeGenerated is set
+func skipGenerated(path string, content []byte) bool {
+	if includeGenerated || !isGenerated(content) {
+		return false
+	}
+	summary.skipped++
+	reportSkipped(path, "generated file")
+	return true
+}
+
+// reportSkipped logs a file or directory that is not processed and why
+func reportSkipped(path, reason string) {
+	logger.Info("skipped", "path", path, "reason", reason)
+}
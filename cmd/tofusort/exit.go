@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes, from least to most severe. A run that fails for several reasons
+// exits with the most severe code.
+const (
+	exitUnsorted = 1 // files need sorting or failed a check
+	exitParse    = 2 // files could not be parsed
+	exitError    = 3 // I/O or usage errors
+)
+
+var quiet bool
+
+// codedError is an error that sets the exit code of the process. A reported
+// error has already been written to stderr and is not printed again by main.
+type codedError struct {
+	code     int
+	err      error
+	reported bool
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// unsortedError reports files that need sorting or fail a check
+func unsortedError(format string, args ...any) error {
+	return &codedError{code: exitUnsorted, err: fmt.Errorf(format, args...)}
+}
+
+// parseError reports a file that could not be parsed
+func parseError(err error) error {
+	return &codedError{code: exitParse, err: fmt.Errorf("failed to parse file: %w", err)}
+}
+
+// ioError reports a file that could not be read or written
+func ioError(format string, args ...any) error {
+	return &codedError{code: exitError, err: fmt.Errorf(format, args...)}
+}
+
+// exitCode returns the most severe exit code among the errors joined or
+// wrapped in err. Errors without a code, such as usage and configuration
+// errors, exit with exitError.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var children []error
+	switch e := err.(type) {
+	case *codedError:
+		return e.code
+	case interface{ Unwrap() []error }:
+		children = e.Unwrap()
+	case interface{ Unwrap() error }:
+		children = []error{e.Unwrap()}
+	default:
+		return exitError
+	}
+
+	code := 0
+	for _, child := range children {
+		code = max(code, exitCode(child))
+	}
+	return code
+}
+
+// unreportedErrors returns err without the errors joined or wrapped in it that
+// have already been reported, or nil when all of them have been
+func unreportedErrors(err error) error {
+	switch e := err.(type) {
+	case *codedError:
+		if e.reported {
+			return nil
+		}
+		return err
+	case interface{ Unwrap() []error }:
+		var errs []error
+		for _, child := range e.Unwrap() {
+			if unreported := unreportedErrors(child); unreported != nil {
+				errs = append(errs, unreported)
+			}
+		}
+		return errors.Join(errs...)
+	case interface{ Unwrap() error }:
+		child := e.Unwrap()
+		unreported := unreportedErrors(child)
+		if unreported == nil {
+			return nil
+		}
+		// Keep the context added by the wrapping error, such as the path
+		if prefix, found := strings.CutSuffix(err.Error(), child.Error()); found && unreported != child {
+			return fmt.Errorf("%s%w", prefix, unreported)
+		}
+		return err
+	default:
+		return err
+	}
+}
+
+// runSummary counts the files handled by a sort or check run
+type runSummary struct {
+	start    time.Time
+	scanned  int
+	changed  int
+	unsorted int
+	errored  int
+	skipped  int
+}
+
+var summary runSummary
+
+// startSummary resets the counts at the start of a run
+func startSummary() {
+	summary = runSummary{start: time.Now()}
+}
+
+// printSummary writes the closing summary line of a run to stderr unless
+// --quiet is set
+func printSummary() {
+	if quiet {
+		return
+	}
+	fmt.Fprintf(
+		os.Stderr,
+		"%d scanned, %d changed, %d unsorted, %d errored, %d skipped in %s\n",
+		summary.scanned,
+		summary.changed,
+		summary.unsorted,
+		summary.errored,
+		summary.skipped,
+		time.Since(summary.start).Round(time.Millisecond),
+	)
+}
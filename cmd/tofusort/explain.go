@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/maxexcloo/tofusort/internal/parser"
+	"github.com/maxexcloo/tofusort/internal/sorter"
+	"github.com/spf13/cobra"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain <file>",
+	Short: "Explain why elements move when a file is sorted",
+	Long: `Explain how sorting would change an OpenTofu/Terraform file without modifying it.
+Each block, attribute and object key that changes line is printed with its old
+and new line and the rule that placed it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runExplain,
+}
+
+func init() {
+	rootCmd.AddCommand(explainCmd)
+}
+
+func runExplain(cmd *cobra.Command, args []string) error {
+	p, err := newParser()
+	if err != nil {
+		return err
+	}
+	s, err := newSorter()
+	if err != nil {
+		return err
+	}
+	return explainFile(args[0], p, s, os.Stdout)
+}
+
+func explainFile(path string, p *parser.Parser, s *sorter.Sorter, w io.Writer) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ioError("failed to read file: %w", err)
+	}
+
+	layout := parser.DetectLayout(content)
+	file, err := p.ParseFile(content, path)
+	if err != nil {
+		return reportParseError(err)
+	}
+
+	var changes []sorter.Change
+	s.SetObserver(sorter.ObserverFunc(func(change sorter.Change) {
+		changes = append(changes, change)
+	}))
+	defer s.SetObserver(nil)
+	logDiagnostics(path, s.SortFile(file))
+
+	formatted := p.FormatFile(file)
+	lines := formattedLines(file.Bytes(), formatted)
+	newContent := p.Restore(formatted, layout)
+
+	moved := 0
+	for _, change := range changes {
+		newLine, found := lines[change.NewLine]
+		if !found {
+			newLine = change.NewLine
+		}
+		if newLine == change.OldLine {
+			continue
+		}
+		fmt.Fprintf(w, "%s:%d -> %d: %s %s: %s [%s]\n", path, change.OldLine, newLine, change.Kind, change.Address, change.Rule, change.RuleID)
+		moved++
+	}
+
+	if moved == 0 {
+		if bytes.Equal(content, newContent) {
+			fmt.Fprintf(w, "Already sorted: %s\n", path)
+		} else {
+			fmt.Fprintf(w, "Only formatting changes: %s\n", path)
+		}
+	}
+	return nil
+}
+
+// formattedLines maps lines of sorted source to the lines they occupy once
+// formatted. Formatting only changes spacing and blank lines, so the other
+// tokens of both sources correspond one to one.
+func formattedLines(sorted, formatted []byte) map[int]int {
+	before := significantTokens(sorted)
+	after := significantTokens(formatted)
+	if len(before) != len(after) {
+		return nil
+	}
+
+	lines := make(map[int]int, len(before))
+	for i, token := range before {
+		if _, exists := lines[token.Range.Start.Line]; !exists {
+			lines[token.Range.Start.Line] = after[i].Range.Start.Line
+		}
+	}
+	return lines
+}
+
+func significantTokens(src []byte) hclsyntax.Tokens {
+	tokens, _ := hclsyntax.LexConfig(src, "", hcl.InitialPos)
+	significant := make(hclsyntax.Tokens, 0, len(tokens))
+	for _, token := range tokens {
+		if token.Type != hclsyntax.TokenNewline && token.Type != hclsyntax.TokenEOF {
+			significant = append(significant, token)
+		}
+	}
+	return significant
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/maxexcloo/tofusort/internal/sorter"
+)
+
+// Log formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var logFormats = []string{logFormatText, logFormatJSON}
+
+var (
+	verbosity int
+	logFormat string
+)
+
+// logger writes diagnostic logs to stderr so that stdout only holds results.
+// It discards everything until setupLogger runs.
+var logger = slog.New(slog.DiscardHandler)
+
+// setupLogger points logger at w in the format selected by --log-format.
+// Warnings are logged by default, file results with -v, discovery and rule
+// decisions with -vv, and only errors with --quiet.
+func setupLogger(w io.Writer) error {
+	level := slog.LevelWarn
+	switch {
+	case quiet:
+		level = slog.LevelError
+	case verbosity == 1:
+		level = slog.LevelInfo
+	case verbosity >= 2:
+		level = slog.LevelDebug
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case logFormatText:
+		logger = slog.New(slog.NewTextHandler(w, options))
+	case logFormatJSON:
+		logger = slog.New(slog.NewJSONHandler(w, options))
+	default:
+		return fmt.Errorf("invalid log format %q: must be one of %s", logFormat, strings.Join(logFormats, ", "))
+	}
+	return nil
+}
+
+// logFile logs the result of sorting or checking a file and how long it took
+func logFile(path, result string, start time.Time, err error) {
+	if err != nil {
+		logger.Info("file failed", "path", path, "duration", time.Since(start), "error", err)
+		return
+	}
+	logger.Info("file "+result, "path", path, "duration", time.Since(start))
+}
+
+// logDiagnostics logs warnings, located by diagnosticLocation
+func logDiagnostics(path string, diags hcl.Diagnostics) {
+	for _, diag := range diags {
+		logger.Warn(diag.Summary, "location", diagnosticLocation(path, diag), "detail", diag.Detail)
+	}
+}
+
+// diagnosticLocation locates a diagnostic by its subject range when it has one
+// and by path otherwise
+func diagnosticLocation(path string, diag *hcl.Diagnostic) string {
+	if diag.Subject == nil {
+		return path
+	}
+	subject := *diag.Subject
+	if subject.Filename == "" {
+		subject.Filename = path
+	}
+	return subject.String()
+}
+
+// logRules logs the rule that placed each element while s sorts path, when
+// debug logging is enabled. The returned function stops logging.
+func logRules(s *sorter.Sorter, path string) func() {
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return func() {}
+	}
+
+	s.SetObserver(sorter.ObserverFunc(func(change sorter.Change) {
+		logger.Debug(
+			"placed element",
+			"path", path,
+			"kind", change.Kind,
+			"address", change.Address,
+			"old_line", change.OldLine,
+			"new_line", change.NewLine,
+			"rule", change.RuleID,
+		)
+	}))
+	return func() { s.SetObserver(nil) }
+}
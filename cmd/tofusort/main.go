@@ -1,22 +1,117 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 
+	"github.com/maxexcloo/tofusort/internal/config"
+	"github.com/maxexcloo/tofusort/internal/parser"
+	"github.com/maxexcloo/tofusort/internal/sorter"
 	"github.com/spf13/cobra"
 )
 
+var (
+	configPath    string
+	style         string
+	lineEndings   string
+	keepGoing     bool
+	sections      bool
+	enabledRules  []string
+	disabledRules []string
+)
+
 var rootCmd = &cobra.Command{
 	Use:   "tofusort",
 	Short: "Sort OpenTofu/Terraform configuration files alphabetically",
 	Long: `tofusort is a tool to sort OpenTofu/Terraform configuration files alphabetically.
-It sorts blocks by type, attributes within blocks, and preserves comments and formatting.`,
+It sorts blocks by type, attributes within blocks, and preserves comments and formatting.
+
+Exit codes: 0 when nothing is wrong, 1 when files need sorting or fail a check,
+2 when files cannot be parsed and 3 for I/O and usage errors. A run that fails
+for several reasons exits with the highest code.`,
+	// Usage is only printed for errors found before a command runs, such as
+	// missing arguments, and main prints errors itself
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return setupLogger(os.Stderr)
+	},
+	SilenceErrors: true,
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Path to configuration file (default "+config.FileName+" if present)")
+	rootCmd.PersistentFlags().StringVar(&style, "style", "", "Style preset the configuration is applied over: "+strings.Join(config.Styles, ", ")+" (default "+config.StyleTofusort+")")
+	rootCmd.PersistentFlags().StringVar(&lineEndings, "line-endings", parser.LineEndingsAuto, "Line endings to write: "+strings.Join(parser.LineEndings, ", ")+"; auto keeps those of each file")
+	rootCmd.PersistentFlags().BoolVar(&keepGoing, "keep-going", false, "Report syntax errors in every block of a file, not only the first")
+	rootCmd.PersistentFlags().BoolVar(&sections, "sections", false, "Sort within sections of a body separated by blank lines and comment lines")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only log errors and do not print the run summary")
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "Log file results with -v, and discovery and rule decisions with -vv")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logFormatText, "Format of logs written to stderr: "+strings.Join(logFormats, ", "))
+	rootCmd.MarkFlagsMutuallyExclusive("quiet", "verbose")
+	rootCmd.PersistentFlags().StringSliceVar(&enabledRules, "enable", nil, "Rules to apply even if disabled in the configuration file")
+	rootCmd.PersistentFlags().StringSliceVar(&disabledRules, "disable", nil, "Rules to skip: "+strings.Join(sorter.RuleIDs(), ", "))
+}
+
+func newParser() (*parser.Parser, error) {
+	p, err := parser.NewWithLineEndings(lineEndings)
+	if err != nil {
+		return nil, err
+	}
+	p.KeepGoing = keepGoing
+	return p, nil
+}
+
+func newSorter() (*sorter.Sorter, error) {
+	cfg, err := config.Load(configPath, style)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range enabledRules {
+		if !slices.Contains(sorter.RuleIDs(), id) {
+			return nil, fmt.Errorf("invalid rule %q: must be one of %s", id, strings.Join(sorter.RuleIDs(), ", "))
+		}
+	}
+
+	// Rules named by --disable are added to those disabled in the
+	// configuration file, then rules named by --enable are removed
+	cfg.DisabledRules = append(slices.Clone(cfg.DisabledRules), disabledRules...)
+	cfg.DisabledRules = slices.DeleteFunc(cfg.DisabledRules, func(id string) bool {
+		return slices.Contains(enabledRules, id)
+	})
+	if sections {
+		cfg.Sections = true
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	logger.Debug("loaded config", "path", configPath, "style", cfg.Style, "disabled_rules", cfg.DisabledRules, "sections", cfg.Sections)
+
+	generatedMarkers = cfg.GeneratedMarkers
+	markdownEnabled = includeMarkdown || cfg.Markdown
+	return sorter.NewWithConfig(cfg.Config), nil
+}
+
+// reportParseError writes the diagnostics of a parse error to stderr with the
+// source lines they refer to, and returns a parse error that main does not
+// print again
+func reportParseError(err error) error {
+	var parseErr *parser.ParseError
+	if !errors.As(err, &parseErr) {
+		return parseError(err)
+	}
+	_ = parseErr.WriteDiagnostics(os.Stderr, 0, false)
+	return &codedError{code: exitParse, err: fmt.Errorf("failed to parse file: %w", err), reported: true}
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		if unreported := unreportedErrors(err); unreported != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", unreported)
+		}
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/maxexcloo/tofusort/internal/markdown"
+	"github.com/maxexcloo/tofusort/internal/parser"
+	"github.com/maxexcloo/tofusort/internal/sorter"
+)
+
+var (
+	includeMarkdown bool
+	markdownEnabled bool
+)
+
+// isMarkdownFile reports whether path is a Markdown document whose code blocks
+// are sorted, which requires --markdown or the markdown configuration option
+func isMarkdownFile(path string) bool {
+	return markdownEnabled && strings.ToLower(filepath.Ext(path)) == ".md"
+}
+
+// isSourceFile reports whether path is sorted by the sort and check commands
+func isSourceFile(path string) bool {
+	return isTerraformFile(path) || isMarkdownFile(path)
+}
+
+// sortMarkdown sorts the HCL code blocks of a Markdown document. Code blocks
+// that do not parse are left unchanged and returned as skipped, apart from
+// the warnings of sorting the other code blocks.
+func sortMarkdown(path string, content []byte, p *parser.Parser, s *sorter.Sorter) (sorted []byte, skipped, diags hcl.Diagnostics) {
+	layout := parser.DetectLayout(content)
+	normalized := parser.Normalize(content)
+	blocks := markdown.Blocks(normalized)
+
+	codes := make([][]byte, len(blocks))
+	for i, block := range blocks {
+		code := block.Code(normalized)
+		if len(bytes.TrimSpace(code)) == 0 {
+			continue
+		}
+
+		file, err := p.ParseFile(code, fmt.Sprintf("%s (code block at line %d)", path, block.Line))
+		if err != nil {
+			fence := hcl.Pos{Line: block.Line, Column: len(block.Indent) + 1}
+			skipped = append(skipped, &hcl.Diagnostic{
+				Severity: hcl.DiagWarning,
+				Summary:  "Skipped code block",
+				Detail:   err.Error(),
+				Subject:  &hcl.Range{Filename: path, Start: fence, End: fence},
+			})
+			continue
+		}
+
+		for _, diag := range s.SortFile(file) {
+			if diag.Subject != nil {
+				subject := documentRange(path, block, *diag.Subject)
+				diag.Subject = &subject
+			}
+			diags = append(diags, diag)
+		}
+		codes[i] = p.FormatFile(file)
+	}
+
+	return p.Restore(markdown.Splice(normalized, blocks, codes), layout), skipped, diags
+}
+
+// documentRange converts a range within a code block to a range within the
+// Markdown document
+func documentRange(path string, block markdown.Block, rng hcl.Range) hcl.Range {
+	shift := func(pos hcl.Pos) hcl.Pos {
+		return hcl.Pos{
+			Line:   block.Line + pos.Line,
+			Column: len(block.Indent) + pos.Column,
+			Byte:   block.Start + pos.Byte + len(block.Indent)*pos.Line,
+		}
+	}
+	return hcl.Range{Filename: path, Start: shift(rng.Start), End: shift(rng.End)}
+}
+
+// checkMarkdown reports whether the code blocks of a Markdown document are
+// not sorted. Code blocks that do not parse are printed as findings.
+func checkMarkdown(path string, content []byte, p *parser.Parser, s *sorter.Sorter) (bool, error) {
+	stop := logRules(s, path)
+	newContent, skipped, diags := sortMarkdown(path, content, p, s)
+	stop()
+	printFindings(path, skipped)
+	logDiagnostics(path, diags)
+
+	if checkIdempotent {
+		if second, _, _ := sortMarkdown(path, newContent, p, s); !bytes.Equal(newContent, second) {
+			return false, idempotenceError(path, &sorter.NotIdempotentError{Filename: path, First: newContent, Second: second})
+		}
+	}
+	cacheResult(content, newContent, append(skipped, diags...))
+
+	return differs(p, content, newContent), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/maxexcloo/tofusort/internal/diff"
+)
+
+var (
+	outputPatch string
+	outDir      string
+)
+
+// patch collects the changes written to --output-patch at the end of a run
+var patch bytes.Buffer
+
+// writeSorted writes the sorted content of a file where the sort flags ask
+// for it: into the patch, under the output directory, nowhere in a dry run,
+// or over the file. Files that would change but are not written, in a dry run
+// or into the patch, are counted as unsorted rather than changed.
+func writeSorted(path string, content, newContent []byte) error {
+	changed := !bytes.Equal(content, newContent)
+
+	switch {
+	case outputPatch != "":
+		if !changed {
+			return nil
+		}
+		name, err := relativePath(path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		fmt.Fprintf(&patch, "diff --git a/%s b/%s\n", name, name)
+		patch.Write(diff.Unified("a/"+name, "b/"+name, content, newContent))
+		fmt.Printf("Would modify: %s\n", path)
+		summary.unsorted++
+		return nil
+
+	case outDir != "":
+		name, err := relativePath(path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(outDir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return ioError("failed to create output directory: %w", err)
+		}
+		if err := os.WriteFile(target, newContent, 0644); err != nil {
+			return ioError("failed to write file: %w", err)
+		}
+		if !changed {
+			return nil
+		}
+		fmt.Printf("Sorted: %s -> %s\n", path, target)
+
+	case !changed:
+		return nil
+
+	case dryRun:
+		fmt.Printf("Would modify: %s\n", path)
+		summary.unsorted++
+		return nil
+
+	default:
+		if err := os.WriteFile(path, newContent, 0644); err != nil {
+			return ioError("failed to write file: %w", err)
+		}
+		fmt.Printf("Sorted: %s\n", path)
+	}
+
+	summary.changed++
+	return nil
+}
+
+// relativePath returns path relative to the working directory, which names
+// files in patches and output directories
+func relativePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", ioError("failed to resolve path: %w", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", ioError("failed to get working directory: %w", err)
+	}
+
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ioError("%s is outside the working directory", path)
+	}
+	return rel, nil
+}
+
+// isOutDir reports whether path is the --out-dir directory, which recursive
+// discovery skips so that sorted copies are not sorted again
+func isOutDir(path string) bool {
+	if outDir == "" {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	target, err := filepath.Abs(outDir)
+	return err == nil && abs == target
+}
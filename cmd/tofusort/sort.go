@@ -1,12 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"io/fs"
 	"os"
-	"path/filepath"
-	"strings"
+	"time"
 
 	"github.com/maxexcloo/tofusort/internal/parser"
 	"github.com/maxexcloo/tofusort/internal/sorter"
@@ -22,20 +21,40 @@ var sortCmd = &cobra.Command{
 	Use:   "sort [file or directory]",
 	Short: "Sort OpenTofu/Terraform files alphabetically",
 	Long: `Sort OpenTofu/Terraform configuration files alphabetically.
-Sorts blocks by type, then by name within type, and attributes within blocks.`,
+Sorts blocks by type, then by name within type, and attributes within blocks.
+
+With --output-patch, the changes to all files are written to a single patch
+that git apply accepts instead. With --out-dir, sorted copies of the files are
+written to a directory that mirrors the input tree. Both name files by their
+path relative to the working directory.`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: runSort,
 }
 
 func init() {
 	sortCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Process directories recursively")
+	sortCmd.Flags().BoolVar(&noCache, "no-cache", false, "Sort every file, ignoring and not updating the cache")
+	sortCmd.Flags().BoolVar(&includeGenerated, "include-generated", false, "Sort generated files and vendored modules, which are skipped by default")
+	sortCmd.Flags().BoolVar(&includeMarkdown, "markdown", false, "Sort HCL code blocks in Markdown files")
 	sortCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be changed without modifying files")
+	sortCmd.Flags().StringVar(&outputPatch, "output-patch", "", "Write the changes to a patch for git apply instead of modifying files")
+	sortCmd.Flags().StringVar(&outDir, "out-dir", "", "Write sorted copies of the files to a directory mirroring the input tree instead of modifying files")
+	sortCmd.MarkFlagsMutuallyExclusive("dry-run", "output-patch", "out-dir")
 	rootCmd.AddCommand(sortCmd)
 }
 
 func runSort(cmd *cobra.Command, args []string) error {
-	p := parser.New()
-	s := sorter.New()
+	p, err := newParser()
+	if err != nil {
+		return err
+	}
+	s, err := newSorter()
+	if err != nil {
+		return err
+	}
+	fileCache = newCache(p, s)
+	startSummary()
+	patch.Reset()
 	var errs []error
 
 	for _, path := range args {
@@ -44,13 +63,22 @@ func runSort(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if outputPatch != "" {
+		if err := os.WriteFile(outputPatch, patch.Bytes(), 0644); err != nil {
+			errs = append(errs, ioError("failed to write patch: %w", err))
+		} else {
+			fmt.Printf("Wrote patch: %s\n", outputPatch)
+		}
+	}
+
+	printSummary()
 	return errors.Join(errs...)
 }
 
 func processPath(path string, p *parser.Parser, s *sorter.Sorter) error {
 	info, err := os.Stat(path)
 	if err != nil {
-		return fmt.Errorf("failed to stat path: %w", err)
+		return ioError("failed to stat path: %w", err)
 	}
 
 	if info.IsDir() {
@@ -61,91 +89,92 @@ func processPath(path string, p *parser.Parser, s *sorter.Sorter) error {
 }
 
 func processDirectory(dir string, p *parser.Parser, s *sorter.Sorter) error {
-	var errs []error
-
-	if recursive {
-		walkErr := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				errs = append(errs, fmt.Errorf("failed to access %s: %w", path, err))
-				return nil
-			}
-
-			if d.IsDir() {
-				return nil
-			}
-
-			if isTerraformFile(path) {
-				if err := processFile(path, p, s); err != nil {
-					errs = append(errs, fmt.Errorf("failed to process %s: %w", path, err))
-				}
-			}
-
-			return nil
-		})
-		if walkErr != nil {
-			errs = append(errs, walkErr)
-		}
-		return errors.Join(errs...)
-	}
-
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return fmt.Errorf("failed to read directory: %w", err)
-	}
+	files, err := discoverFiles(dir)
+	errs := []error{err}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		path := filepath.Join(dir, entry.Name())
-		if isTerraformFile(path) {
-			if err := processFile(path, p, s); err != nil {
-				errs = append(errs, fmt.Errorf("failed to process %s: %w", path, err))
-			}
+	for _, path := range files {
+		if err := processFile(path, p, s); err != nil {
+			errs = append(errs, fmt.Errorf("failed to process %s: %w", path, err))
 		}
 	}
 
 	return errors.Join(errs...)
 }
 
-func processFile(path string, p *parser.Parser, s *sorter.Sorter) error {
-	if !isTerraformFile(path) {
+// processFile sorts a file and writes the result in place, to the patch or to
+// the output directory, or reports whether it would change in a dry run.
+// Errors are returned as *codedError, or joined when a file that fails to
+// parse cannot be copied to the output directory either.
+func processFile(path string, p *parser.Parser, s *sorter.Sorter) (err error) {
+	if !isSourceFile(path) {
 		return nil
 	}
 
+	summary.scanned++
+	result := "unchanged"
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			summary.errored++
+		}
+		logFile(path, result, start, err)
+	}()
+
 	content, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return ioError("failed to read file: %w", err)
 	}
 
-	file, err := p.ParseFile(content)
-	if err != nil {
-		return fmt.Errorf("failed to parse file: %w", err)
+	// Skipped files and files that fail to parse are written unchanged, so
+	// that the output directory mirrors the input tree
+	if skipGenerated(path, content) {
+		result = "skipped"
+		return writeSorted(path, content, content)
 	}
 
-	s.SortFile(file)
+	newContent := content
+	if cachedSorted(content) {
+		result = "cached"
+	} else {
+		newContent, err = sortContent(path, content, p, s)
+		if err != nil {
+			if writeErr := writeSorted(path, content, content); writeErr != nil {
+				return errors.Join(err, writeErr)
+			}
+			return err
+		}
+	}
 
-	newContent := p.FormatFile(file)
+	if !bytes.Equal(content, newContent) {
+		result = "changed"
+	}
+	return writeSorted(path, content, newContent)
+}
 
-	if dryRun {
-		if string(content) != string(newContent) {
-			fmt.Printf("Would modify: %s\n", path)
-		}
-		return nil
+// sortContent parses, sorts and formats the content of a file, or the HCL
+// code blocks of a Markdown document
+func sortContent(path string, content []byte, p *parser.Parser, s *sorter.Sorter) ([]byte, error) {
+	if isMarkdownFile(path) {
+		stop := logRules(s, path)
+		newContent, skipped, diags := sortMarkdown(path, content, p, s)
+		stop()
+		diags = append(skipped, diags...)
+		logDiagnostics(path, diags)
+		cacheResult(content, newContent, diags)
+		return newContent, nil
 	}
 
-	if string(content) != string(newContent) {
-		if err := os.WriteFile(path, newContent, 0644); err != nil {
-			return fmt.Errorf("failed to write file: %w", err)
-		}
-		fmt.Printf("Sorted: %s\n", path)
+	layout := parser.DetectLayout(content)
+	file, err := p.ParseFile(content, path)
+	if err != nil {
+		return nil, reportParseError(err)
 	}
 
-	return nil
-}
+	defer logRules(s, path)()
+	diags := s.SortFile(file)
+	logDiagnostics(path, diags)
 
-func isTerraformFile(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	return ext == ".tf" || ext == ".tfvars"
+	newContent := p.Restore(p.FormatFile(file), layout)
+	cacheResult(content, newContent, diags)
+	return newContent, nil
 }
@@ -0,0 +1,185 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// definition is a named declaration that may appear only once in its scope
+type definition struct {
+	Kind    string
+	Address string
+	Range   hcl.Range
+}
+
+// Duplicates reports declarations defined more than once across the files of
+// a single module directory, keyed by path: block addresses, locals,
+// required_providers entries, and keys within object constructors. Files that
+// fail to parse are skipped, including tfvars files that assign a variable
+// twice, which the parser already rejects.
+func Duplicates(files map[string][]byte) hcl.Diagnostics {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var diags hcl.Diagnostics
+	seen := make(map[string]definition)
+	for _, path := range paths {
+		file, parseDiags := hclsyntax.ParseConfig(files[path], path, hcl.InitialPos)
+		if parseDiags.HasErrors() {
+			continue
+		}
+		body, ok := file.Body.(*hclsyntax.Body)
+		if !ok {
+			continue
+		}
+
+		for _, def := range definitions(body) {
+			key := def.Kind + "\x00" + def.Address
+			if previous, exists := seen[key]; exists {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagWarning,
+					Summary:  "Duplicate " + def.Kind,
+					Detail:   fmt.Sprintf("%s was already defined at %s.", def.Address, previous.Range),
+					Subject:  def.Range.Ptr(),
+				})
+				continue
+			}
+			seen[key] = def
+		}
+
+		diags = append(diags, objectKeyDuplicates(body)...)
+	}
+
+	return diags
+}
+
+// definitions returns the declarations in a file body
+func definitions(body *hclsyntax.Body) []definition {
+	var defs []definition
+	for _, block := range body.Blocks {
+		switch block.Type {
+		case "resource", "data", "ephemeral":
+			if len(block.Labels) == 2 {
+				address := block.Labels[0] + "." + block.Labels[1]
+				if block.Type != "resource" {
+					address = block.Type + "." + address
+				}
+				defs = append(defs, definition{Kind: block.Type + " block", Address: address, Range: block.DefRange()})
+			}
+		case "module", "variable", "output", "check":
+			if len(block.Labels) == 1 {
+				address := block.Type + "." + block.Labels[0]
+				if block.Type == "variable" {
+					address = "var." + block.Labels[0]
+				}
+				defs = append(defs, definition{Kind: block.Type + " block", Address: address, Range: block.DefRange()})
+			}
+		case "provider":
+			if len(block.Labels) == 1 {
+				defs = append(defs, definition{Kind: "provider configuration", Address: providerAddress(block), Range: block.DefRange()})
+			}
+		case "locals":
+			for _, attr := range sortedAttributes(block.Body) {
+				defs = append(defs, definition{Kind: "local value", Address: "local." + attr.Name, Range: attr.NameRange})
+			}
+		case "terraform":
+			for _, nested := range block.Body.Blocks {
+				if nested.Type != "required_providers" {
+					continue
+				}
+				for _, attr := range sortedAttributes(nested.Body) {
+					defs = append(defs, definition{Kind: "required provider", Address: "required_providers." + attr.Name, Range: attr.NameRange})
+				}
+			}
+		}
+	}
+
+	return defs
+}
+
+// providerAddress returns the address of a provider configuration, including
+// its alias when the alias is a literal string
+func providerAddress(block *hclsyntax.Block) string {
+	address := "provider." + block.Labels[0]
+	if attr, exists := block.Body.Attributes["alias"]; exists {
+		if template, ok := attr.Expr.(*hclsyntax.TemplateExpr); ok && template.IsStringLiteral() {
+			if value, diags := template.Value(nil); !diags.HasErrors() {
+				address += "." + value.AsString()
+			}
+		}
+	}
+	return address
+}
+
+// sortedAttributes returns the attributes of a body in source order
+func sortedAttributes(body *hclsyntax.Body) []*hclsyntax.Attribute {
+	attrs := make([]*hclsyntax.Attribute, 0, len(body.Attributes))
+	for _, attr := range body.Attributes {
+		attrs = append(attrs, attr)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].SrcRange.Start.Byte < attrs[j].SrcRange.Start.Byte
+	})
+	return attrs
+}
+
+// objectKeyDuplicates reports keys set more than once in an object constructor
+func objectKeyDuplicates(body *hclsyntax.Body) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	_ = hclsyntax.VisitAll(body, func(node hclsyntax.Node) hcl.Diagnostics {
+		object, ok := node.(*hclsyntax.ObjectConsExpr)
+		if !ok {
+			return nil
+		}
+
+		seen := make(map[string]hcl.Range, len(object.Items))
+		for _, item := range object.Items {
+			key, ok := staticObjectKey(item.KeyExpr)
+			if !ok {
+				continue
+			}
+			if previous, exists := seen[key]; exists {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagWarning,
+					Summary:  "Duplicate object key",
+					Detail:   fmt.Sprintf("The key %q was already set at %s.", key, previous),
+					Subject:  item.KeyExpr.Range().Ptr(),
+				})
+				continue
+			}
+			seen[key] = item.KeyExpr.Range()
+		}
+		return nil
+	})
+	return diags
+}
+
+// staticObjectKey returns the key of an object item written as an identifier
+// or a literal string
+func staticObjectKey(expr hclsyntax.Expression) (string, bool) {
+	if keyExpr, ok := expr.(*hclsyntax.ObjectConsKeyExpr); ok {
+		if keyExpr.ForceNonLiteral {
+			return "", false
+		}
+		if keyword := hcl.ExprAsKeyword(keyExpr.Wrapped); keyword != "" {
+			return keyword, true
+		}
+		expr = keyExpr.Wrapped
+	}
+
+	template, ok := expr.(*hclsyntax.TemplateExpr)
+	if !ok || !template.IsStringLiteral() {
+		return "", false
+	}
+	value, diags := template.Value(nil)
+	if diags.HasErrors() {
+		return "", false
+	}
+	return value.AsString(), true
+}
@@ -0,0 +1,102 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	files := map[string][]byte{
+		"main.tf": []byte(`resource "aws_instance" "web" {
+  tags = {
+    Name = "web"
+    Name = "duplicate"
+  }
+}
+
+locals {
+  region = "us-east-1"
+}
+
+provider "aws" {
+  alias = "east"
+}
+`),
+		"other.tf": []byte(`resource "aws_instance" "web" {
+  ami = "ami-12345"
+}
+
+data "aws_instance" "web" {
+}
+
+locals {
+  region = "us-west-2"
+}
+
+provider "aws" {
+  alias = "west"
+}
+
+terraform {
+  required_providers {
+    aws = {
+      source = "hashicorp/aws"
+    }
+  }
+}
+`),
+		"versions.tf": []byte(`terraform {
+  required_providers {
+    aws = {
+      source = "hashicorp/aws"
+    }
+  }
+}
+`),
+		"terraform.tfvars":  []byte("region = \"us-east-1\"\n"),
+		"prod.auto.tfvars":  []byte("region = \"us-west-2\"\n"),
+		"staging.tfvars":    []byte("region = \"eu-west-1\"\n"),
+		"invalid-syntax.tf": []byte("resource {"),
+	}
+
+	diags := Duplicates(files)
+
+	expected := []string{
+		`main.tf:4,5-9: Duplicate object key; The key "Name" was already set at main.tf:3,5-9.`,
+		`other.tf:1,1-30: Duplicate resource block; aws_instance.web was already defined at main.tf:1,1-30.`,
+		`other.tf:9,3-9: Duplicate local value; local.region was already defined at main.tf:9,3-9.`,
+		`versions.tf:3,5-8: Duplicate required provider; required_providers.aws was already defined at other.tf:18,5-8.`,
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("Duplicates() returned %d diagnostics, want %d:\n%s", len(diags), len(expected), diags.Error())
+	}
+	for i, diag := range diags {
+		if diag.Error() != expected[i] {
+			t.Errorf("diagnostic %d = %q, want %q", i, diag.Error(), expected[i])
+		}
+	}
+}
+
+func TestDuplicatesIgnoresSeparateTfvarsFiles(t *testing.T) {
+	files := map[string][]byte{
+		"dev.tfvars":       []byte("region = \"us-east-1\"\n"),
+		"prod.tfvars":      []byte("region = \"us-west-2\"\n"),
+		"terraform.tfvars": []byte("region = \"eu-west-1\"\n"),
+		"prod.auto.tfvars": []byte("region = \"eu-west-2\"\n"),
+	}
+
+	if diags := Duplicates(files); len(diags) != 0 {
+		t.Errorf("Duplicates() = %s, want no diagnostics", diags.Error())
+	}
+}
+
+func TestDuplicateDefaultProviders(t *testing.T) {
+	files := map[string][]byte{
+		"main.tf": []byte("provider \"aws\" {}\nprovider \"aws\" {}\n"),
+	}
+
+	diags := Duplicates(files)
+	if len(diags) != 1 || !strings.Contains(diags[0].Detail, "provider.aws was already defined") {
+		t.Errorf("Duplicates() = %v, want a duplicate provider.aws", diags)
+	}
+}
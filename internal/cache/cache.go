@@ -0,0 +1,86 @@
+// Package cache records file contents that are already sorted, so that later
+// runs with the same tofusort version and configuration can skip them.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Cache stores one empty marker file per sorted content, named by a hash of
+// the content and the cache's salt. Markers are only ever created or removed
+// as a whole, so concurrent runs sharing a directory see either no entry or a
+// complete one.
+type Cache struct {
+	dir  string
+	salt [sha256.Size]byte
+}
+
+// Dir returns the default cache directory, tofusort under the user cache
+// directory ($XDG_CACHE_HOME on Linux).
+func Dir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find cache directory: %w", err)
+	}
+	return filepath.Join(dir, "tofusort"), nil
+}
+
+// New returns a cache in dir whose entries only match runs with the same
+// version and configuration. Each part of the configuration is hashed in
+// order, so callers should pass them in a stable order.
+func New(dir, version string, config ...[]byte) *Cache {
+	hash := sha256.New()
+	hash.Write([]byte(version))
+	for _, part := range config {
+		hash.Write([]byte{0})
+		hash.Write(part)
+	}
+
+	c := &Cache{dir: dir}
+	hash.Sum(c.salt[:0])
+	return c
+}
+
+// Sorted reports whether content was recorded as sorted.
+func (c *Cache) Sorted(content []byte) bool {
+	_, err := os.Stat(c.path(content))
+	return err == nil
+}
+
+// MarkSorted records content as sorted.
+func (c *Cache) MarkSorted(content []byte) error {
+	path := c.path(content)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return file.Close()
+}
+
+// Clean removes the cache directory and every entry in it.
+func Clean(dir string) error {
+	if err := os.RemoveAll(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove cache: %w", err)
+	}
+	return nil
+}
+
+// path returns the marker file for content, spread over subdirectories named
+// by the first byte of its key
+func (c *Cache) path(content []byte) string {
+	hash := sha256.New()
+	hash.Write(c.salt[:])
+	hash.Write(content)
+	key := hex.EncodeToString(hash.Sum(nil))
+	return filepath.Join(c.dir, key[:2], key[2:])
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCacheKeys(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("a = 1\n")
+
+	c := New(dir, "v1", []byte(`{"collation":"bytewise"}`))
+	if c.Sorted(content) {
+		t.Fatal("Sorted() = true for an empty cache")
+	}
+	if err := c.MarkSorted(content); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Sorted(content) {
+		t.Error("Sorted() = false after MarkSorted()")
+	}
+	if c.Sorted([]byte("a = 2\n")) {
+		t.Error("Sorted() = true for different content")
+	}
+
+	if New(dir, "v2", []byte(`{"collation":"bytewise"}`)).Sorted(content) {
+		t.Error("Sorted() = true for a different version")
+	}
+	if New(dir, "v1", []byte(`{"collation":"natural"}`)).Sorted(content) {
+		t.Error("Sorted() = true for a different configuration")
+	}
+	if !New(dir, "v1", []byte(`{"collation":"bytewise"}`)).Sorted(content) {
+		t.Error("Sorted() = false for the same version and configuration")
+	}
+}
+
+func TestCacheConcurrentUse(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	c := New(dir, "v1")
+
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content := []byte{byte(i % 4)}
+			if err := c.MarkSorted(content); err != nil {
+				t.Error(err)
+			}
+			if !c.Sorted(content) {
+				t.Errorf("Sorted(%v) = false after MarkSorted()", content)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := Clean(dir); err != nil {
+		t.Fatal(err)
+	}
+	if c.Sorted([]byte{0}) {
+		t.Error("Sorted() = true after Clean()")
+	}
+	if err := Clean(dir); err != nil {
+		t.Errorf("Clean() of a missing directory = %v", err)
+	}
+}
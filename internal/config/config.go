@@ -0,0 +1,118 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/maxexcloo/tofusort/internal/sorter"
+)
+
+// FileName is the configuration file used when no explicit path is given.
+const FileName = ".tofusort.json"
+
+// Config is the user configuration read from a tofusort configuration file.
+type Config struct {
+	sorter.Config
+
+	// Style names the built-in preset the rest of the file is applied over.
+	Style string `json:"style,omitempty"`
+
+	// SchemaFile is a provider schema JSON document, as written by
+	// `tofu providers schema -json`, whose set-typed attributes are added to
+	// the sorter's set attributes. Relative paths are resolved against the
+	// directory of the configuration file.
+	SchemaFile string `json:"schema_file,omitempty"`
+
+	// GeneratedMarkers lists text that marks a file as generated when it
+	// appears in the comment lines at the start of the file, in addition to
+	// the "Code generated ... DO NOT EDIT." convention. Generated files are
+	// skipped unless --include-generated is given.
+	GeneratedMarkers []string `json:"generated_markers,omitempty"`
+
+	// EnabledRules removes rules from DisabledRules, such as rules disabled by
+	// the style preset.
+	EnabledRules []string `json:"enabled_rules,omitempty"`
+
+	// Markdown enables sorting the HCL code blocks of .md files, as the
+	// --markdown flag does.
+	Markdown bool `json:"markdown,omitempty"`
+}
+
+func Default() Config {
+	return Config{
+		Config: sorter.DefaultConfig(),
+	}
+}
+
+// Load reads the configuration file at path on top of a style preset. An
+// empty path loads FileName from the working directory when it exists. A
+// non-empty style replaces the style named in the file.
+func Load(path, style string) (Config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = FileName
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil && (explicit || !errors.Is(err, fs.ErrNotExist)) {
+		return Default(), fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if style == "" && content != nil {
+		var file struct {
+			Style string `json:"style"`
+		}
+		if err := json.Unmarshal(content, &file); err != nil {
+			return Default(), fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+		style = file.Style
+	}
+	if style == "" {
+		style = StyleTofusort
+	}
+
+	cfg, err := Preset(style)
+	if err != nil {
+		return cfg, err
+	}
+	if content == nil {
+		return cfg, nil
+	}
+
+	if err := decode(content, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	cfg.Style = style
+	if cfg.SchemaFile != "" {
+		schemaFile := cfg.SchemaFile
+		if !filepath.IsAbs(schemaFile) {
+			schemaFile = filepath.Join(filepath.Dir(path), schemaFile)
+		}
+		setAttributes, err := LoadSetAttributes(schemaFile)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.SetAttributes = append(cfg.SetAttributes, setAttributes...)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Validate reports configuration values tofusort does not recognise.
+func (c Config) Validate() error {
+	for _, id := range c.EnabledRules {
+		if !slices.Contains(sorter.RuleIDs(), id) {
+			return fmt.Errorf("invalid rule %q: must be one of %s", id, strings.Join(sorter.RuleIDs(), ", "))
+		}
+	}
+	return c.Config.Validate()
+}
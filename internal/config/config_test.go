@@ -0,0 +1,197 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/maxexcloo/tofusort/internal/sorter"
+)
+
+func TestLoadOverridesArgumentOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	content := `{"argument_order": {"provider": {"first": ["region"]}, "resource": {"last": ["tags"]}}}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := cfg.ArgumentOrder["provider"].First; len(first) != 1 || first[0] != "region" {
+		t.Errorf("provider first = %v, want [region]", first)
+	}
+	if last := cfg.ArgumentOrder["resource"].Last; len(last) != 1 || last[0] != "tags" {
+		t.Errorf("resource last = %v, want [tags]", last)
+	}
+	if _, exists := cfg.ArgumentOrder["module"]; !exists {
+		t.Error("built-in module order was dropped")
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte(`{"argument_ordering": {}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path, ""); err == nil || !strings.Contains(err.Error(), "argument_ordering") {
+		t.Errorf("Load() error = %v, want unknown field error", err)
+	}
+}
+
+func TestLoadMissingExplicitFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json"), ""); err == nil {
+		t.Error("Load() returned nil for a missing explicit file")
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte(`{"moved_order": "random"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path, ""); err == nil || !strings.Contains(err.Error(), "moved_order") {
+		t.Errorf("Load() error = %v, want moved_order error", err)
+	}
+}
+
+func TestLoadRejectsUnknownRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte(`{"disabled_rules": ["object-keys", "key-order"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path, ""); err == nil || !strings.Contains(err.Error(), "key-order") {
+		t.Errorf("Load() error = %v, want invalid rule error", err)
+	}
+}
+
+func TestPresetsAreValid(t *testing.T) {
+	for _, style := range Styles {
+		cfg, err := Preset(style)
+		if err != nil {
+			t.Fatalf("Preset(%q) error = %v", style, err)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Preset(%q) is invalid: %v", style, err)
+		}
+	}
+
+	if _, err := Preset("google"); err == nil {
+		t.Error("Preset() returned nil for an unknown style")
+	}
+}
+
+func TestLoadAppliesStyle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte(`{"style": "minimal", "disabled_rules": ["spacing"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Style != StyleMinimal {
+		t.Errorf("Style = %q, want %q", cfg.Style, StyleMinimal)
+	}
+	preset, err := Preset(StyleMinimal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cfg.DisabledRules, preset.DisabledRules) {
+		t.Errorf("DisabledRules = %v, want the minimal preset's %v", cfg.DisabledRules, preset.DisabledRules)
+	}
+
+	cfg, err = Load(path, StyleHashiCorp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Style != StyleHashiCorp || cfg.LabelOrder != sorter.LabelOrderAuthored {
+		t.Errorf("Style = %q, LabelOrder = %q, want hashicorp preset", cfg.Style, cfg.LabelOrder)
+	}
+}
+
+func TestLoadEnablesPresetRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte(`{"style": "minimal", "enabled_rules": ["attribute-order", "spacing"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range cfg.DisabledRules {
+		if id == sorter.RuleAttributeOrder || id == sorter.RuleSpacing {
+			t.Errorf("DisabledRules = %v, want attribute-order and spacing enabled", cfg.DisabledRules)
+		}
+	}
+	if !slices.Contains(cfg.DisabledRules, sorter.RuleObjectKeys) {
+		t.Errorf("DisabledRules = %v, want the preset's other rules disabled", cfg.DisabledRules)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"enabled_rules": ["key-order"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, ""); err == nil || !strings.Contains(err.Error(), "key-order") {
+		t.Errorf("Load() error = %v, want invalid rule error", err)
+	}
+}
+
+func TestLoadSchemaSetAttributes(t *testing.T) {
+	directory := t.TempDir()
+	schema := `{
+  "format_version": "1.0",
+  "provider_schemas": {
+    "registry.opentofu.org/hashicorp/aws": {
+      "resource_schemas": {
+        "aws_instance": {
+          "block": {
+            "attributes": {
+              "ami": {"type": "string"},
+              "security_groups": {"type": ["set", "string"]},
+              "secondary_private_ips": {"type": ["list", "string"]}
+            },
+            "block_types": {
+              "ebs_block_device": {
+                "nesting_mode": "set",
+                "block": {"attributes": {"tags_all": {"type": ["set", "string"]}}}
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+	if err := os.WriteFile(filepath.Join(directory, "schema.json"), []byte(schema), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(directory, FileName)
+	if err := os.WriteFile(path, []byte(`{"sort_sets": true, "schema_file": "schema.json"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"depends_on",
+		"resource.aws_instance.ebs_block_device.tags_all",
+		"resource.aws_instance.security_groups",
+	} {
+		if !slices.Contains(cfg.SetAttributes, expected) {
+			t.Errorf("SetAttributes = %v, missing %s", cfg.SetAttributes, expected)
+		}
+	}
+	if slices.Contains(cfg.SetAttributes, "resource.aws_instance.secondary_private_ips") {
+		t.Errorf("SetAttributes = %v, includes a list attribute", cfg.SetAttributes)
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Built-in style presets
+const (
+	StyleTofusort  = "tofusort"
+	StyleHashiCorp = "hashicorp"
+	StyleMinimal   = "minimal"
+)
+
+// Styles lists the built-in style presets.
+var Styles = []string{StyleTofusort, StyleHashiCorp, StyleMinimal}
+
+// Presets are configuration files in the same format as FileName, applied over
+// the defaults before the user's configuration
+//
+//go:embed presets/*.json
+var presets embed.FS
+
+// Preset returns the configuration of a built-in style: the defaults with the
+// style's preset file applied.
+func Preset(style string) (Config, error) {
+	cfg := Default()
+	if !slices.Contains(Styles, style) {
+		return cfg, fmt.Errorf("invalid style %q: must be one of %s", style, strings.Join(Styles, ", "))
+	}
+
+	content, err := presets.ReadFile("presets/" + style + ".json")
+	if err != nil {
+		return cfg, err
+	}
+	if err := decode(content, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse %s style: %w", style, err)
+	}
+	cfg.Style = style
+
+	return cfg, nil
+}
+
+// decode reads a configuration document over cfg, rejecting unknown fields.
+// disabled_rules and generated_markers add to the lists in cfg, and
+// enabled_rules then removes rules from disabled_rules. Entries in map fields
+// replace the entry with the same key, and other fields replace the value.
+func decode(content []byte, cfg *Config) error {
+	disabledRules, generatedMarkers := cfg.DisabledRules, cfg.GeneratedMarkers
+	cfg.DisabledRules, cfg.GeneratedMarkers, cfg.EnabledRules = nil, nil, nil
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return err
+	}
+
+	cfg.DisabledRules = appendMissing(disabledRules, cfg.DisabledRules)
+	cfg.GeneratedMarkers = appendMissing(generatedMarkers, cfg.GeneratedMarkers)
+	cfg.DisabledRules = slices.DeleteFunc(cfg.DisabledRules, func(id string) bool {
+		return slices.Contains(cfg.EnabledRules, id)
+	})
+	return nil
+}
+
+// appendMissing returns list followed by the values of extra not in it
+func appendMissing(list, extra []string) []string {
+	list = slices.Clone(list)
+	for _, value := range extra {
+		if !slices.Contains(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
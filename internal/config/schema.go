@@ -0,0 +1,78 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+)
+
+// providerSchemas is the subset of `tofu providers schema -json` output used
+// to find attributes that hold sets
+type providerSchemas struct {
+	ProviderSchemas map[string]struct {
+		Provider                 schemaEntry            `json:"provider"`
+		ResourceSchemas          map[string]schemaEntry `json:"resource_schemas"`
+		DataSourceSchemas        map[string]schemaEntry `json:"data_source_schemas"`
+		EphemeralResourceSchemas map[string]schemaEntry `json:"ephemeral_resource_schemas"`
+	} `json:"provider_schemas"`
+}
+
+type schemaEntry struct {
+	Block schemaBlock `json:"block"`
+}
+
+type schemaBlock struct {
+	Attributes map[string]struct {
+		Type json.RawMessage `json:"type"`
+	} `json:"attributes"`
+	BlockTypes map[string]schemaEntry `json:"block_types"`
+}
+
+// LoadSetAttributes reads a provider schema JSON document and returns the
+// dotted block paths of all set-typed attributes, such as
+// resource.aws_instance.security_groups.
+func LoadSetAttributes(filename string) ([]string, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	var schemas providerSchemas
+	if err := json.Unmarshal(content, &schemas); err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %w", filename, err)
+	}
+
+	var paths []string
+	for source, provider := range schemas.ProviderSchemas {
+		paths = provider.Provider.Block.setAttributes("provider."+path.Base(source), paths)
+		for name, resource := range provider.ResourceSchemas {
+			paths = resource.Block.setAttributes("resource."+name, paths)
+		}
+		for name, dataSource := range provider.DataSourceSchemas {
+			paths = dataSource.Block.setAttributes("data."+name, paths)
+		}
+		for name, resource := range provider.EphemeralResourceSchemas {
+			paths = resource.Block.setAttributes("ephemeral."+name, paths)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// setAttributes appends the paths of set-typed attributes in the block and its
+// nested blocks to paths
+func (b schemaBlock) setAttributes(prefix string, paths []string) []string {
+	for name, attr := range b.Attributes {
+		var typ []json.RawMessage
+		if json.Unmarshal(attr.Type, &typ) == nil && len(typ) > 0 && string(typ[0]) == `"set"` {
+			paths = append(paths, prefix+"."+name)
+		}
+	}
+	for name, nested := range b.BlockTypes {
+		paths = nested.Block.setAttributes(prefix+"."+name, paths)
+	}
+	return paths
+}
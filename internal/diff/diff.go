@@ -0,0 +1,263 @@
+// Package diff writes unified diffs between two versions of a file.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around each change
+const context = 3
+
+// maxSteps bounds the search for a split of two ranges. Ranges needing more
+// edits than this are written as one replacement, which is correct but
+// longer, keeping diffs of heavily reordered files fast.
+const maxSteps = 1024
+
+// Unified returns a unified diff turning a into b, with oldName and newName in
+// its header, or nil when a and b are equal.
+func Unified(oldName, newName string, a, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+
+	oldLines, newLines := splitLines(a), splitLines(b)
+	edits := compare(oldLines, newLines)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+	for start := 0; start < len(edits); {
+		// Find the next change and the end of its hunk, merging changes
+		// separated by fewer than twice the context lines
+		first := start
+		for first < len(edits) && edits[first].kind == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		last := first
+		for i := first; i < len(edits) && i-last <= 2*context; i++ {
+			if edits[i].kind != ' ' {
+				last = i
+			}
+		}
+
+		from := max(first-context, start)
+		to := min(last+context+1, len(edits))
+		writeHunk(&buf, edits[from:to])
+		start = to
+	}
+	return buf.Bytes()
+}
+
+// edit is a line kept (' '), removed ('-') or added ('+'), with its line
+// numbers in the old and new file
+type edit struct {
+	kind    byte
+	line    string
+	oldLine int
+	newLine int
+}
+
+// writeHunk writes a hunk header followed by its lines
+func writeHunk(buf *bytes.Buffer, edits []edit) {
+	oldStart, newStart := edits[0].oldLine, edits[0].newLine
+	oldCount, newCount := 0, 0
+	for _, e := range edits {
+		if e.kind != '+' {
+			oldCount++
+		}
+		if e.kind != '-' {
+			newCount++
+		}
+	}
+
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+	for _, e := range edits {
+		buf.WriteByte(e.kind)
+		buf.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the start and length of a hunk. Empty ranges start at the
+// line before them.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprint(start)
+	default:
+		return fmt.Sprintf("%d,%d", start, count)
+	}
+}
+
+// splitLines splits content after each newline, keeping the newlines
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// compare returns the edits turning a into b, removals before additions
+func compare(a, b []string) []edit {
+	d := differ{
+		a:       a,
+		b:       b,
+		removed: make([]bool, len(a)),
+		added:   make([]bool, len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	edits := make([]edit, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && d.removed[i]:
+			edits = append(edits, edit{kind: '-', line: a[i], oldLine: i + 1, newLine: j + 1})
+			i++
+		case j < len(b) && d.added[j]:
+			edits = append(edits, edit{kind: '+', line: b[j], oldLine: i + 1, newLine: j + 1})
+			j++
+		default:
+			edits = append(edits, edit{kind: ' ', line: a[i], oldLine: i + 1, newLine: j + 1})
+			i++
+			j++
+		}
+	}
+	return edits
+}
+
+// differ marks the lines removed from a and added in b along a shortest edit
+// script, found with Myers' linear space algorithm
+type differ struct {
+	a, b    []string
+	removed []bool
+	added   []bool
+}
+
+// compare marks the edits turning a[aLo:aHi] into b[bLo:bHi]
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.added[j] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.removed[i] = true
+		}
+	default:
+		// Both ranges differ at their first and last lines, so a split lies
+		// strictly inside them and each half is smaller
+		if x, y, found := d.split(aLo, aHi, bLo, bHi); found {
+			d.compare(aLo, x, bLo, y)
+			d.compare(x, aHi, y, bHi)
+			return
+		}
+		for i := aLo; i < aHi; i++ {
+			d.removed[i] = true
+		}
+		for j := bLo; j < bHi; j++ {
+			d.added[j] = true
+		}
+	}
+}
+
+// split finds a point on a shortest edit script between a[aLo:aHi] and
+// b[bLo:bHi] by searching forwards from the start and backwards from the end
+// until the two searches overlap, or reports false after maxSteps steps.
+// Diagonals that leave the edit graph are dropped from later steps.
+func (d *differ) split(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	n, m := aHi-aLo, bHi-bLo
+	maxD := min((n+m+1)/2, maxSteps)
+	offset := maxD + 1
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - m
+	odd := delta%2 != 0
+	var forwardStart, forwardEnd, backwardStart, backwardEnd int
+	for step := 0; step < maxD; step++ {
+		for k := -step + forwardStart; k <= step-forwardEnd; k += 2 {
+			x := furthest(forward, offset, k, step)
+			y := x - k
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+
+			switch {
+			case x > n:
+				forwardEnd += 2
+			case y > m:
+				forwardStart += 2
+			case odd:
+				// Diagonal k forwards is diagonal delta-k backwards
+				back := offset + delta - k
+				if back >= 0 && back < len(backward) && backward[back] != -1 && x >= n-backward[back] {
+					return aLo + x, bLo + y, true
+				}
+			}
+		}
+
+		for k := -step + backwardStart; k <= step-backwardEnd; k += 2 {
+			x := furthest(backward, offset, k, step)
+			y := x - k
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+
+			switch {
+			case x > n:
+				backwardEnd += 2
+			case y > m:
+				backwardStart += 2
+			case !odd:
+				front := offset + delta - k
+				if front < 0 || front >= len(forward) || forward[front] == -1 {
+					continue
+				}
+				frontX := forward[front]
+				frontY := frontX - (delta - k)
+				if frontX >= n-x && frontX <= n && frontY >= 0 && frontY <= m {
+					return aLo + frontX, bLo + frontY, true
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// furthest returns the starting x of a search step on diagonal k, extending
+// the further reaching of its neighbouring diagonals
+func furthest(v []int, offset, k, step int) int {
+	if k == -step || (k != step && v[offset+k-1] < v[offset+k+1]) {
+		return v[offset+k+1]
+	}
+	return v[offset+k-1] + 1
+}
@@ -0,0 +1,135 @@
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		a, b     string
+		expected string
+	}{
+		"equal": {a: "a\nb\n", b: "a\nb\n", expected: ""},
+		"change": {
+			a: "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b: "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			expected: `--- old
++++ new
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+`,
+		},
+		"separate hunks": {
+			a: "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			b: "A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n",
+			expected: `--- old
++++ new
+@@ -1,4 +1,4 @@
+-a
++A
+ 1
+ 2
+ 3
+@@ -7,4 +7,4 @@
+ 6
+ 7
+ 8
+-b
++B
+`,
+		},
+		"insert into empty": {
+			a: "",
+			b: "a\n",
+			expected: `--- old
++++ new
+@@ -0,0 +1 @@
++a
+`,
+		},
+		"missing final newline": {
+			a: "a\nb",
+			b: "a\nb\n",
+			expected: `--- old
++++ new
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`,
+		},
+	}
+	for name, tt := range tests {
+		if actual := string(Unified("old", "new", []byte(tt.a), []byte(tt.b))); actual != tt.expected {
+			t.Errorf("%s: diff =\n%s\nwant\n%s", name, actual, tt.expected)
+		}
+	}
+}
+
+func TestCompareFindsShortestEdits(t *testing.T) {
+	t.Parallel()
+
+	random := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + random.Intn(3)))
+		}
+		return lines
+	}
+
+	for range 2000 {
+		a, b := randomLines(), randomLines()
+		edits := compare(a, b)
+
+		var oldLines, newLines []string
+		changes := 0
+		for _, e := range edits {
+			if e.kind != '+' {
+				oldLines = append(oldLines, e.line)
+			}
+			if e.kind != '-' {
+				newLines = append(newLines, e.line)
+			}
+			if e.kind != ' ' {
+				changes++
+			}
+		}
+		if strings.Join(oldLines, ",") != strings.Join(a, ",") || strings.Join(newLines, ",") != strings.Join(b, ",") {
+			t.Fatalf("edits of %v to %v do not rebuild them", a, b)
+		}
+		if shortest := len(a) + len(b) - 2*longestCommon(a, b); changes != shortest {
+			t.Fatalf("%d edits of %v to %v, want %d", changes, a, b, shortest)
+		}
+	}
+}
+
+// longestCommon returns the length of the longest common subsequence of a and b
+func longestCommon(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+	return lengths[0][0]
+}
@@ -0,0 +1,119 @@
+// Package markdown finds fenced code blocks holding HCL in Markdown documents
+// and splices sorted code back into them.
+package markdown
+
+import (
+	"bytes"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Languages lists the info string languages of code blocks holding HCL.
+var Languages = []string{"hcl", "terraform", "tf", "tofu"}
+
+var (
+	openingFence = regexp.MustCompile("^( *)(`{3,}|~{3,})(.*)$")
+	closingFence = regexp.MustCompile("^ *(`{3,}|~{3,})[ \t]*$")
+)
+
+// Block is a fenced code block holding HCL.
+type Block struct {
+	// Line is the 1-based line of the opening fence.
+	Line int
+
+	// Start and End are the byte offsets of the lines between the fences.
+	Start, End int
+
+	// Indent is the indentation of the opening fence, which is removed from
+	// the lines of the code and restored when splicing.
+	Indent string
+}
+
+// Blocks returns the fenced code blocks of content whose info string starts
+// with one of Languages, in document order. Content must use LF line endings.
+// Fences that are not closed are ignored.
+func Blocks(content []byte) []Block {
+	var blocks []Block
+	var fence string
+	var current *Block
+
+	offset := 0
+	for i, line := range strings.SplitAfter(string(content), "\n") {
+		start := offset
+		offset += len(line)
+		line = strings.TrimSuffix(line, "\n")
+
+		if fence == "" {
+			match := openingFence.FindStringSubmatch(line)
+			if match == nil || (match[2][0] == '`' && strings.Contains(match[3], "`")) {
+				continue
+			}
+			fence = match[2]
+			current = nil
+			if slices.Contains(Languages, language(match[3])) {
+				current = &Block{Line: i + 1, Start: offset, Indent: match[1]}
+			}
+			continue
+		}
+
+		// A closing fence uses the opening fence character at least as many
+		// times
+		match := closingFence.FindStringSubmatch(line)
+		if match == nil || match[1][0] != fence[0] || len(match[1]) < len(fence) {
+			continue
+		}
+		if current != nil {
+			current.End = start
+			blocks = append(blocks, *current)
+		}
+		fence = ""
+	}
+
+	return blocks
+}
+
+// language returns the language named by the first word of an info string
+func language(info string) string {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
+
+// Code returns the code of the block with the fence indentation removed.
+func (b Block) Code(content []byte) []byte {
+	var code bytes.Buffer
+	for _, line := range bytes.SplitAfter(content[b.Start:b.End], []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " ")
+		if len(line)-len(trimmed) > len(b.Indent) {
+			trimmed = line[len(b.Indent):]
+		}
+		code.Write(trimmed)
+	}
+	return code.Bytes()
+}
+
+// Splice returns content with the code of each block replaced by the code at
+// the same index in codes, indented like the opening fence. Blocks with a nil
+// code are left unchanged.
+func Splice(content []byte, blocks []Block, codes [][]byte) []byte {
+	var out bytes.Buffer
+	offset := 0
+	for i, block := range blocks {
+		if codes[i] == nil {
+			continue
+		}
+		out.Write(content[offset:block.Start])
+		for _, line := range bytes.SplitAfter(codes[i], []byte("\n")) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				out.WriteString(block.Indent)
+			}
+			out.Write(line)
+		}
+		offset = block.End
+	}
+	out.Write(content[offset:])
+	return out.Bytes()
+}
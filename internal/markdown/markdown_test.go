@@ -0,0 +1,72 @@
+package markdown
+
+import (
+	"testing"
+)
+
+func TestBlocks(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content  string
+		expected []string
+	}{
+		"languages": {
+			content:  "```hcl\na = 1\n```\n\n```terraform\nb = 1\n```\n\n~~~tf\nc = 1\n~~~\n\n```Tofu title=\"main.tf\"\nd = 1\n```\n",
+			expected: []string{"a = 1\n", "b = 1\n", "c = 1\n", "d = 1\n"},
+		},
+		"other languages": {
+			content:  "```\na = 1\n```\n\n```sh\ntofu plan\n```\n\n```hcl2\nb = 1\n```\n",
+			expected: nil,
+		},
+		"fence inside another language": {
+			content:  "````markdown\n```hcl\na = 1\n```\n````\n\n```hcl\nb = 1\n```\n",
+			expected: []string{"b = 1\n"},
+		},
+		"longer closing fence": {
+			content:  "````hcl\na = <<EOT\n```\nEOT\n`````\n",
+			expected: []string{"a = <<EOT\n```\nEOT\n"},
+		},
+		"indented": {
+			content:  "1. Add:\n\n   ```hcl\n   a = {\n     b = 1\n   }\n\n   ```\n",
+			expected: []string{"a = {\n  b = 1\n}\n\n"},
+		},
+		"unclosed": {
+			content:  "```hcl\na = 1\n",
+			expected: nil,
+		},
+	}
+
+	for name, test := range tests {
+		content := []byte(test.content)
+		blocks := Blocks(content)
+		if len(blocks) != len(test.expected) {
+			t.Errorf("%s: Blocks() found %d blocks, want %d", name, len(blocks), len(test.expected))
+			continue
+		}
+		for i, block := range blocks {
+			if code := string(block.Code(content)); code != test.expected[i] {
+				t.Errorf("%s: block %d code = %q, want %q", name, i, code, test.expected[i])
+			}
+		}
+	}
+}
+
+func TestSplice(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("# Usage\n\n```hcl\nb = 1\na = 2\n```\n\n- Item\n\n  ```tf\n  d = {\n  }\n  c = 3\n  ```\n\n```hcl\nz = 1\n```\n")
+	blocks := Blocks(content)
+	if len(blocks) != 3 {
+		t.Fatalf("Blocks() found %d blocks, want 3", len(blocks))
+	}
+	if blocks[1].Line != 10 {
+		t.Errorf("Blocks() line = %d, want 10", blocks[1].Line)
+	}
+
+	codes := [][]byte{[]byte("a = 2\nb = 1\n"), []byte("c = 3\n\nd = {\n}\n"), nil}
+	expected := "# Usage\n\n```hcl\na = 2\nb = 1\n```\n\n- Item\n\n  ```tf\n  c = 3\n\n  d = {\n  }\n  ```\n\n```hcl\nz = 1\n```\n"
+	if actual := string(Splice(content, blocks, codes)); actual != expected {
+		t.Errorf("Splice() = %q, want %q", actual, expected)
+	}
+}
@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"io"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// ParseError reports the syntax errors of a file, with the normalised source
+// they refer to so that they can be shown in context.
+type ParseError struct {
+	Filename    string
+	Source      []byte
+	Diagnostics hcl.Diagnostics
+}
+
+func (e *ParseError) Error() string {
+	return e.Diagnostics.Error()
+}
+
+// WriteDiagnostics writes each diagnostic with its location and a source
+// snippet marking the problem, wrapped at width columns unless width is 0.
+func (e *ParseError) WriteDiagnostics(w io.Writer, width uint, color bool) error {
+	// The partial syntax tree of the file lets the writer name the block
+	// each diagnostic is in
+	file, _ := hclsyntax.ParseConfig(e.Source, e.Filename, hcl.InitialPos)
+	if file == nil {
+		file = &hcl.File{Bytes: e.Source}
+	}
+	files := map[string]*hcl.File{e.Filename: file}
+	return hcl.NewDiagnosticTextWriter(w, files, width, color).WriteDiagnostics(e.Diagnostics)
+}
+
+// parseItems parses each top-level item of content on its own, so that a
+// syntax error in one block does not hide those in the blocks after it. Items
+// are taken to start with an identifier in the first column, as they do in
+// formatted files. When no item fails on its own, diags is returned.
+func parseItems(content []byte, filename string, diags hcl.Diagnostics) hcl.Diagnostics {
+	tokens, _ := hclsyntax.LexConfig(content, filename, hcl.InitialPos)
+	starts := []hcl.Pos{hcl.InitialPos}
+	for _, token := range tokens {
+		if token.Type == hclsyntax.TokenIdent && token.Range.Start.Column == 1 && token.Range.Start.Byte > 0 {
+			starts = append(starts, token.Range.Start)
+		}
+	}
+
+	var itemDiags hcl.Diagnostics
+	for i, start := range starts {
+		end := len(content)
+		if i+1 < len(starts) {
+			end = starts[i+1].Byte
+		}
+		_, parseDiags := hclsyntax.ParseConfig(content[start.Byte:end], filename, start)
+		itemDiags = append(itemDiags, parseDiags...)
+	}
+
+	if !itemDiags.HasErrors() {
+		return diags
+	}
+	return itemDiags
+}
@@ -1,23 +1,106 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
 	"regexp"
 
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
 )
 
-type Parser struct{}
+// Line ending modes
+const (
+	LineEndingsAuto = "auto"
+	LineEndingsLF   = "lf"
+	LineEndingsCRLF = "crlf"
+)
+
+// LineEndings lists the line ending modes.
+var LineEndings = []string{LineEndingsAuto, LineEndingsLF, LineEndingsCRLF}
+
+var byteOrderMark = []byte("\xef\xbb\xbf")
+
+type Parser struct {
+	// LineEndings selects the line endings written by Restore: those of the
+	// input file (auto), LF or CRLF.
+	LineEndings string
+
+	// KeepGoing makes ParseFile report the syntax errors of every top-level
+	// block of a file that fails to parse, rather than only the first.
+	KeepGoing bool
+}
 
 func New() *Parser {
-	return &Parser{}
+	return &Parser{LineEndings: LineEndingsAuto}
+}
+
+// NewWithLineEndings returns a parser that writes the given line endings.
+func NewWithLineEndings(lineEndings string) (*Parser, error) {
+	switch lineEndings {
+	case LineEndingsAuto, LineEndingsLF, LineEndingsCRLF:
+	default:
+		return nil, fmt.Errorf("invalid line endings %q: must be %q, %q or %q", lineEndings, LineEndingsAuto, LineEndingsLF, LineEndingsCRLF)
+	}
+	return &Parser{LineEndings: lineEndings}, nil
+}
+
+// Layout records the parts of a file's encoding that parsing discards: a UTF-8
+// byte-order mark and CRLF line endings.
+type Layout struct {
+	BOM  bool
+	CRLF bool
+}
+
+// DetectLayout reports whether content starts with a byte-order mark and
+// whether most of its lines end in CRLF.
+func DetectLayout(content []byte) Layout {
+	crlf := bytes.Count(content, []byte("\r\n"))
+	return Layout{
+		BOM:  bytes.HasPrefix(content, byteOrderMark),
+		CRLF: crlf > 0 && crlf*2 > bytes.Count(content, []byte("\n")),
+	}
+}
+
+// Normalize removes a byte-order mark and converts CRLF line endings to LF,
+// the form FormatFile produces.
+func Normalize(content []byte) []byte {
+	content = bytes.TrimPrefix(content, byteOrderMark)
+	return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
 }
 
-func (p *Parser) ParseFile(content []byte) (*hclwrite.File, error) {
-	file, diags := hclwrite.ParseConfig(content, "", hcl.Pos{})
+// Restore converts formatted content back to the layout of the input file,
+// with line endings chosen by the parser's LineEndings mode.
+func (p *Parser) Restore(content []byte, layout Layout) []byte {
+	crlf := layout.CRLF
+	switch p.LineEndings {
+	case LineEndingsLF:
+		crlf = false
+	case LineEndingsCRLF:
+		crlf = true
+	}
+
+	if crlf {
+		content = bytes.ReplaceAll(content, []byte("\n"), []byte("\r\n"))
+	}
+	if layout.BOM {
+		content = append(append([]byte(nil), byteOrderMark...), content...)
+	}
+	return content
+}
+
+// ParseFile parses content after normalising it, so that files with a
+// byte-order mark or CRLF line endings are sorted like any other. Syntax
+// errors are returned as a *ParseError located in filename.
+func (p *Parser) ParseFile(content []byte, filename string) (*hclwrite.File, error) {
+	content = Normalize(content)
+	file, diags := hclwrite.ParseConfig(content, filename, hcl.InitialPos)
 	if diags.HasErrors() {
-		return nil, fmt.Errorf("failed to parse HCL: %s", diags.Error())
+		if p.KeepGoing {
+			diags = parseItems(content, filename, diags)
+		}
+		return nil, &ParseError{Filename: filename, Source: content, Diagnostics: diags}
 	}
 	return file, nil
 }
@@ -30,23 +113,67 @@ func (p *Parser) FormatFile(file *hclwrite.File) []byte {
 }
 
 func (p *Parser) cleanupBlankLines(content []byte) []byte {
-	text := string(content)
-
-	// Replace multiple consecutive empty lines with single empty line
-	// This regex matches 3 or more consecutive newlines and replaces with 2 newlines
-	re := regexp.MustCompile(`\n\n\n+`)
-	text = re.ReplaceAllString(text, "\n\n")
-
-	// Clean up blank lines at the start of blocks (after opening brace)
-	// This handles the case where we have "{\n\n\n  attribute"
-	blockStartRe := regexp.MustCompile(`\{\n\n+(\s+)`)
-	text = blockStartRe.ReplaceAllString(text, "{\n$1")
+	// Blank lines are only cleaned up outside heredocs, whose lines are part
+	// of their values
+	var b bytes.Buffer
+	start := 0
+	for _, body := range heredocBodies(content) {
+		b.Write(cleanupSegment(content[start:body.Start.Byte]))
+		b.Write(content[body.Start.Byte:body.End.Byte])
+		start = body.End.Byte
+	}
+	b.Write(cleanupSegment(content[start:]))
+	text := b.String()
 
 	// Remove blank lines at the start of file
-	text = regexp.MustCompile(`^\n+`).ReplaceAllString(text, "")
+	text = leadingBlankLines.ReplaceAllString(text, "")
 
 	// Ensure file ends with exactly one newline
-	text = regexp.MustCompile(`\n*$`).ReplaceAllString(text, "\n")
+	text = trailingSpace.ReplaceAllString(text, "\n")
 
 	return []byte(text)
 }
+
+var (
+	// blankLines matches 3 or more consecutive newlines
+	blankLines = regexp.MustCompile(`\n\n\n+`)
+	// blockStart matches blank lines after an opening brace, as in
+	// "{\n\n\n  attribute"
+	blockStart        = regexp.MustCompile(`\{\n\n+(\s+)`)
+	leadingBlankLines = regexp.MustCompile(`^\n+`)
+	trailingSpace     = regexp.MustCompile(`\s*$`)
+)
+
+// cleanupSegment replaces multiple consecutive empty lines with a single
+// empty line and removes blank lines at the start of blocks
+func cleanupSegment(content []byte) []byte {
+	content = blankLines.ReplaceAll(content, []byte("\n\n"))
+	return blockStart.ReplaceAll(content, []byte("{\n$1"))
+}
+
+// heredocBodies returns the ranges of the lines of each heredoc in content,
+// between its opening and closing markers
+func heredocBodies(content []byte) []hcl.Range {
+	var bodies []hcl.Range
+	tokens, _ := hclsyntax.LexConfig(content, "", hcl.InitialPos)
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].Type != hclsyntax.TokenOHeredoc {
+			continue
+		}
+		// Heredocs interpolated within this one end before its closing marker
+		open := tokens[i]
+		for depth := 0; i < len(tokens)-1; {
+			i++
+			if tokens[i].Type == hclsyntax.TokenOHeredoc {
+				depth++
+			} else if tokens[i].Type == hclsyntax.TokenCHeredoc {
+				if depth == 0 {
+					bodies = append(bodies, hcl.Range{Start: open.Range.End, End: tokens[i].Range.Start})
+					break
+				}
+				depth--
+			}
+		}
+	}
+	return bodies
+}
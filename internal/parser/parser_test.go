@@ -0,0 +1,131 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDetectLayout(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]Layout{
+		"a = 1\n":                        {},
+		"a = 1\r\nb = 2\r\n":             {CRLF: true},
+		"a = 1\r\nb = 2\r\nc = 3\n":      {CRLF: true},
+		"a = 1\r\nb = 2\nc = 3\n":        {},
+		"\xef\xbb\xbfa = 1\r\n":          {BOM: true, CRLF: true},
+		"\xef\xbb\xbfa = 1\nb = 2\r\n\n": {BOM: true},
+	}
+	for content, expected := range tests {
+		if actual := DetectLayout([]byte(content)); actual != expected {
+			t.Errorf("DetectLayout(%q) = %+v, want %+v", content, actual, expected)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	t.Parallel()
+
+	layout := Layout{BOM: true, CRLF: true}
+	tests := map[string]string{
+		LineEndingsAuto: "\xef\xbb\xbfa = 1\r\nb = 2\r\n",
+		LineEndingsLF:   "\xef\xbb\xbfa = 1\nb = 2\n",
+		LineEndingsCRLF: "\xef\xbb\xbfa = 1\r\nb = 2\r\n",
+	}
+	for lineEndings, expected := range tests {
+		p, err := NewWithLineEndings(lineEndings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := string(p.Restore([]byte("a = 1\nb = 2\n"), layout)); actual != expected {
+			t.Errorf("Restore() with %s = %q, want %q", lineEndings, actual, expected)
+		}
+	}
+
+	if _, err := NewWithLineEndings("cr"); err == nil {
+		t.Error("NewWithLineEndings() returned nil for an invalid mode")
+	}
+}
+
+func TestFormatFileBlankLines(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"\n\na = 1\n\n\n\nb = {\n\n\n  c = 1\n}\n\n": "a = 1\n\nb = {\n  c = 1\n}\n",
+		"a = <<EOT\n{\n\n\n  b\nEOT\n":               "a = <<EOT\n{\n\n\n  b\nEOT\n",
+		" ":                                          "\n",
+	}
+	p := New()
+	for content, expected := range tests {
+		file, err := p.ParseFile([]byte(content), "main.tf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := string(p.FormatFile(file)); actual != expected {
+			t.Errorf("FormatFile(%q) = %q, want %q", content, actual, expected)
+		}
+	}
+}
+
+func TestParseFileDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	content := []byte(`resource "aws_instance" "web" {
+  ami = "ami-12345" +
+}
+
+variable "region" {
+  type = = string
+}
+
+output "id" {
+  value = aws_instance.web.id
+}
+`)
+
+	_, err := New().ParseFile(content, "main.tf")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("ParseFile() error = %v, want *ParseError", err)
+	}
+	if len(parseErr.Diagnostics) != 1 || !strings.HasPrefix(err.Error(), "main.tf:2,") {
+		t.Errorf("ParseFile() error = %v, want one error on main.tf line 2", err)
+	}
+
+	var output bytes.Buffer
+	if err := parseErr.WriteDiagnostics(&output, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`on main.tf line 2, in resource "aws_instance" "web":`, `   2:   ami = "ami-12345" +`} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("WriteDiagnostics() output does not contain %q:\n%s", expected, output.String())
+		}
+	}
+
+	p := New()
+	p.KeepGoing = true
+	_, err = p.ParseFile(content, "main.tf")
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("ParseFile() error = %v, want *ParseError", err)
+	}
+	if len(parseErr.Diagnostics) != 2 || parseErr.Diagnostics[1].Subject.Start.Line != 6 {
+		t.Errorf("ParseFile() with KeepGoing = %v, want errors on lines 2 and 6", parseErr.Diagnostics)
+	}
+}
+
+func TestParseFileRepeatedAssignment(t *testing.T) {
+	t.Parallel()
+
+	_, err := New().ParseFile([]byte("a = 1\nb = 2\na = 3\n"), "terraform.tfvars")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("ParseFile() error = %v, want *ParseError", err)
+	}
+	for _, expected := range []string{"terraform.tfvars:3,1-2: Attribute redefined", "terraform.tfvars:1,1-2"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("ParseFile() error = %v, want it to contain %q", err, expected)
+		}
+	}
+}
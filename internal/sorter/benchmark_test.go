@@ -0,0 +1,51 @@
+package sorter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/maxexcloo/tofusort/internal/parser"
+)
+
+// generateTfvars returns a tfvars file of about the given number of lines, in
+// the shape of generated variable files: many scalar assignments followed by
+// a large map of nested objects, all in reverse order
+func generateTfvars(lines int) []byte {
+	var b strings.Builder
+	assignments := lines / 2
+	for i := assignments; i > 0; i-- {
+		fmt.Fprintf(&b, "var_%06d = \"value-%d\"\n", i, i)
+	}
+
+	b.WriteString("services = {\n")
+	for i := (lines - assignments) / 6; i > 0; i-- {
+		fmt.Fprintf(&b, "  service_%06d = {\n", i)
+		fmt.Fprintf(&b, "    tags  = { team = \"t%d\", env = \"prod\" }\n", i)
+		fmt.Fprintf(&b, "    port  = %d\n", 8000+i)
+		fmt.Fprintf(&b, "    hosts = [\"b-%d\", \"a-%d\"]\n", i, i)
+		fmt.Fprintf(&b, "    name  = \"service-%d\"\n", i)
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+
+	return []byte(b.String())
+}
+
+func BenchmarkSortFile(b *testing.B) {
+	for _, lines := range []int{2500, 10000, 40000} {
+		content := generateTfvars(lines)
+		b.Run(fmt.Sprintf("lines=%d", lines), func(b *testing.B) {
+			p := parser.New()
+			s := New()
+			b.SetBytes(int64(len(content)))
+			for b.Loop() {
+				file, err := p.ParseFile(content, "generated.tfvars")
+				if err != nil {
+					b.Fatal(err)
+				}
+				s.SortFile(file)
+			}
+		})
+	}
+}
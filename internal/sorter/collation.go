@@ -0,0 +1,103 @@
+package sorter
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Collations for block labels, attribute names and object keys
+const (
+	CollationBytewise               = "bytewise"
+	CollationNatural                = "natural"
+	CollationCaseInsensitive        = "case-insensitive"
+	CollationNaturalCaseInsensitive = "natural-case-insensitive"
+)
+
+var collations = []string{
+	CollationBytewise,
+	CollationNatural,
+	CollationCaseInsensitive,
+	CollationNaturalCaseInsensitive,
+}
+
+// less reports whether a sorts before b under the configured collation
+func (s *Sorter) less(a, b string) bool {
+	return compareStrings(a, b, s.config.Collation) < 0
+}
+
+// compareStrings compares two strings under a collation. Strings that are
+// equal under a case-insensitive or natural collation fall back to bytewise
+// comparison so the order is deterministic.
+func compareStrings(a, b, collation string) int {
+	var result int
+	switch collation {
+	case CollationNatural:
+		result = compareNatural(a, b, false)
+	case CollationCaseInsensitive:
+		result = strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	case CollationNaturalCaseInsensitive:
+		result = compareNatural(a, b, true)
+	}
+
+	if result != 0 {
+		return result
+	}
+	return strings.Compare(a, b)
+}
+
+// compareNatural compares strings treating runs of digits as numbers, so that
+// "web2" sorts before "web10" and "9" before "10"
+func compareNatural(a, b string, foldCase bool) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			digitsA, digitsB := leadingDigits(a), leadingDigits(b)
+			if result := compareNumeric(digitsA, digitsB); result != 0 {
+				return result
+			}
+			a, b = a[len(digitsA):], b[len(digitsB):]
+			continue
+		}
+
+		runeA, sizeA := utf8.DecodeRuneInString(a)
+		runeB, sizeB := utf8.DecodeRuneInString(b)
+		if foldCase {
+			runeA, runeB = unicode.ToLower(runeA), unicode.ToLower(runeB)
+		}
+		if runeA != runeB {
+			if runeA < runeB {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[sizeA:], b[sizeB:]
+	}
+
+	return len(a) - len(b)
+}
+
+// compareNumeric compares two digit strings by value, ordering equal values
+// with fewer leading zeros first
+func compareNumeric(a, b string) int {
+	trimmedA := strings.TrimLeft(a, "0")
+	trimmedB := strings.TrimLeft(b, "0")
+	if len(trimmedA) != len(trimmedB) {
+		return len(trimmedA) - len(trimmedB)
+	}
+	if result := strings.Compare(trimmedA, trimmedB); result != 0 {
+		return result
+	}
+	return len(a) - len(b)
+}
+
+func leadingDigits(s string) string {
+	end := 0
+	for end < len(s) && isDigit(s[end]) {
+		end++
+	}
+	return s[:end]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
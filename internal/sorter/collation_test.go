@@ -0,0 +1,37 @@
+package sorter
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompareStrings(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string][]string{
+		CollationBytewise:               {"10", "9", "Zone", "app", "web10", "web2"},
+		CollationNatural:                {"9", "10", "Zone", "app", "web2", "web10"},
+		CollationCaseInsensitive:        {"10", "9", "app", "web10", "web2", "Zone"},
+		CollationNaturalCaseInsensitive: {"9", "10", "app", "web2", "web10", "Zone"},
+	}
+	for collation, expected := range tests {
+		actual := []string{"web10", "Zone", "10", "app", "9", "web2"}
+		slices.SortFunc(actual, func(a, b string) int {
+			return compareStrings(a, b, collation)
+		})
+		if !slices.Equal(actual, expected) {
+			t.Errorf("%s order = %v, want %v", collation, actual, expected)
+		}
+	}
+}
+
+func TestCompareNaturalLeadingZeros(t *testing.T) {
+	t.Parallel()
+
+	if compareStrings("a01", "a1", CollationNatural) <= 0 {
+		t.Error("a01 should sort after a1")
+	}
+	if compareStrings("a01", "a2", CollationNatural) >= 0 {
+		t.Error("a01 should sort before a2")
+	}
+}
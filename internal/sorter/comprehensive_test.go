@@ -137,6 +137,7 @@ provider "tailscale" {
   oauth_client_secret = var.terraform.tailscale.oauth_client_secret
   tailnet             = var.terraform.tailscale.organization
 }
+
 provider "tfe" {
   # Uses TF_TOKEN environment variable or OpenTofu/Terraform Cloud credentials
 }
@@ -276,6 +277,7 @@ variable "services" {
     ])
   }
 }
+
 variable "terraform" {
   description = "Provider configurations and credentials"
   sensitive   = true
@@ -415,9 +417,9 @@ func TestProxmoxProviderForEach(t *testing.T) {
 }`
 
 	expected := `provider "proxmox" {
+  alias    = "by_host"
   for_each = nonsensitive(var.terraform.proxmox)
 
-  alias    = "by_host"
   endpoint = "https://${each.value.host}:${each.value.port}"
   insecure = true
   password = each.value.password
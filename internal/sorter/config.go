@@ -0,0 +1,233 @@
+package sorter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Locals ordering modes
+const (
+	LocalsOrderAlphabetical = "alphabetical"
+	LocalsOrderDependency   = "dependency"
+)
+
+// Moved block ordering modes
+const (
+	MovedOrderAuthored    = "authored"
+	MovedOrderTopological = "topological"
+)
+
+// Label ordering modes for top-level blocks of the same type
+const (
+	LabelOrderSorted   = "sorted"
+	LabelOrderAuthored = "authored"
+)
+
+// Config controls the ordering rules applied by the Sorter.
+type Config struct {
+	// BlockOrder lists top-level block types in output order. Unlisted types
+	// follow, sorted alphabetically.
+	BlockOrder []string `json:"block_order,omitempty"`
+
+	// LabelOrder selects how top-level blocks of the same type are ordered:
+	// sorted by label, or in authored order so that only types are grouped.
+	LabelOrder string `json:"label_order,omitempty"`
+
+	// Collation selects how block labels, attribute names and object keys
+	// are compared.
+	Collation string `json:"collation,omitempty"`
+
+	// LocalsOrder selects how locals are ordered: alphabetically, or so that
+	// each local follows the locals it references.
+	LocalsOrder string `json:"locals_order,omitempty"`
+
+	// Sections enables sorting attributes and nested blocks within sections
+	// of a body, which are separated by blank lines and standalone comment
+	// lines. Sections keep their authored order and comments stay in place.
+	Sections bool `json:"sections,omitempty"`
+
+	// SortSets enables sorting the elements of list literals that hold sets:
+	// attributes listed in SetAttributes and lists passed to toset.
+	SortSets bool `json:"sort_sets,omitempty"`
+
+	// SortJSONHeredocs enables sorting the object keys of JSON documents in
+	// heredocs without template sequences.
+	SortJSONHeredocs bool `json:"sort_json_heredocs,omitempty"`
+
+	// SetAttributes lists attributes holding sets, either by name or by a
+	// dotted block path such as resource.aws_instance.security_groups.
+	SetAttributes []string `json:"set_attributes,omitempty"`
+
+	// MovedOrder selects how moved blocks are ordered: in authored order, or
+	// topologically so that moved chains read from first to last step.
+	MovedOrder string `json:"moved_order,omitempty"`
+
+	// EncodedKeyOrder lists keys placed first, in list order, in objects passed
+	// to jsonencode and yamlencode, such as Version before Statement in IAM
+	// policies.
+	EncodedKeyOrder []string `json:"encoded_key_order,omitempty"`
+
+	// DisabledRules lists rules that are not applied, by ID. Elements those
+	// rules would order keep their authored order.
+	DisabledRules []string `json:"disabled_rules,omitempty"`
+
+	// ArgumentOrder pins arguments and nested blocks of a block type to the
+	// start or end of its body, keyed by block type.
+	ArgumentOrder map[string]ArgumentOrder `json:"argument_order,omitempty"`
+}
+
+// ArgumentOrder lists argument names and nested block types that are written
+// before and after the remaining, alphabetically sorted, contents of a block.
+// ObjectKeys lists keys placed first in object values assigned to the block's
+// arguments.
+type ArgumentOrder struct {
+	First      []string `json:"first,omitempty"`
+	Last       []string `json:"last,omitempty"`
+	ObjectKeys []string `json:"object_keys,omitempty"`
+}
+
+var defaultBlockOrder = []string{
+	"terraform",
+	"provider",
+	"variable",
+	"locals",
+	"data",
+	"ephemeral",
+	"resource",
+	"module",
+	"check",
+	"output",
+	"import",
+	"moved",
+	"removed",
+}
+
+var defaultSetAttributes = []string{
+	"depends_on",
+	"ignore_changes",
+}
+
+var defaultEncodedKeyOrder = []string{
+	// IAM policy documents
+	"Version",
+	"Id",
+	"Statement",
+	"Sid",
+	"Effect",
+	"Principal",
+	"NotPrincipal",
+	"Action",
+	"NotAction",
+	"Resource",
+	"NotResource",
+	"Condition",
+	// Kubernetes manifests
+	"apiVersion",
+	"kind",
+	"metadata",
+	"spec",
+}
+
+var defaultArgumentOrder = map[string]ArgumentOrder{
+	"check": {
+		First: []string{"data"},
+		Last:  []string{"assert"},
+	},
+	"encryption": {
+		First: []string{"key_provider", "method", "state", "plan", "remote_state_data_sources"},
+	},
+	"import": {
+		First: []string{"for_each", "provider", "to", "id", "identity"},
+	},
+	"lifecycle": {
+		First: []string{"create_before_destroy", "prevent_destroy", "ignore_changes", "replace_triggered_by"},
+	},
+	"module": {
+		First: []string{"source", "version", "providers", "count", "for_each"},
+		Last:  []string{"depends_on"},
+	},
+	"moved": {
+		First: []string{"from", "to"},
+	},
+	"output": {
+		First: []string{"description", "value", "sensitive", "ephemeral"},
+		Last:  []string{"depends_on", "precondition"},
+	},
+	"provider": {
+		First: []string{"alias"},
+	},
+	"removed": {
+		First: []string{"from"},
+	},
+	"required_providers": {
+		ObjectKeys: []string{"source", "version", "configuration_aliases"},
+	},
+	"terraform": {
+		First: []string{
+			"required_version",
+			"required_providers",
+			"backend",
+			"cloud",
+			"experiments",
+			"provider_meta",
+			"encryption",
+		},
+	},
+}
+
+// DefaultConfig returns the built-in configuration. The returned value is a
+// copy and may be modified freely.
+func DefaultConfig() Config {
+	argumentOrder := make(map[string]ArgumentOrder, len(defaultArgumentOrder))
+	for blockType, order := range defaultArgumentOrder {
+		argumentOrder[blockType] = ArgumentOrder{
+			First:      append([]string(nil), order.First...),
+			Last:       append([]string(nil), order.Last...),
+			ObjectKeys: append([]string(nil), order.ObjectKeys...),
+		}
+	}
+
+	return Config{
+		ArgumentOrder:   argumentOrder,
+		BlockOrder:      append([]string(nil), defaultBlockOrder...),
+		Collation:       CollationBytewise,
+		EncodedKeyOrder: append([]string(nil), defaultEncodedKeyOrder...),
+		LabelOrder:      LabelOrderSorted,
+		LocalsOrder:     LocalsOrderAlphabetical,
+		MovedOrder:      MovedOrderAuthored,
+		SetAttributes:   append([]string(nil), defaultSetAttributes...),
+	}
+}
+
+// Validate reports configuration values the sorter does not recognise.
+func (c Config) Validate() error {
+	if !slices.Contains(collations, c.Collation) {
+		return fmt.Errorf("invalid collation %q: must be one of %s", c.Collation, strings.Join(collations, ", "))
+	}
+
+	for _, id := range c.DisabledRules {
+		if !slices.Contains(RuleIDs(), id) {
+			return fmt.Errorf("invalid rule %q: must be one of %s", id, strings.Join(RuleIDs(), ", "))
+		}
+	}
+
+	switch c.LabelOrder {
+	case LabelOrderSorted, LabelOrderAuthored:
+	default:
+		return fmt.Errorf("invalid label_order %q: must be %q or %q", c.LabelOrder, LabelOrderSorted, LabelOrderAuthored)
+	}
+
+	switch c.LocalsOrder {
+	case LocalsOrderAlphabetical, LocalsOrderDependency:
+	default:
+		return fmt.Errorf("invalid locals_order %q: must be %q or %q", c.LocalsOrder, LocalsOrderAlphabetical, LocalsOrderDependency)
+	}
+
+	switch c.MovedOrder {
+	case MovedOrderAuthored, MovedOrderTopological:
+	default:
+		return fmt.Errorf("invalid moved_order %q: must be %q or %q", c.MovedOrder, MovedOrderAuthored, MovedOrderTopological)
+	}
+	return nil
+}
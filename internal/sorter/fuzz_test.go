@@ -0,0 +1,225 @@
+package sorter
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/maxexcloo/tofusort/internal/parser"
+)
+
+func FuzzSortFile(f *testing.F) {
+	addFixtureSeeds(f)
+	p := parser.New()
+
+	f.Fuzz(func(t *testing.T, content []byte) {
+		for _, sections := range []bool{false, true} {
+			file, err := p.ParseFile(content, "fuzz.tf")
+			if err != nil {
+				return
+			}
+
+			config := DefaultConfig()
+			config.Sections = sections
+			s := NewWithConfig(config)
+			s.SortFile(file)
+			sorted := p.FormatFile(file)
+
+			if err := s.CheckIdempotent(p, sorted, "fuzz.tf"); err != nil {
+				t.Fatalf("%v with sections %t\ninput:\n%s", err, sections, content)
+			}
+			assertEquivalent(t, parser.Normalize(content), sorted)
+		}
+	})
+}
+
+func FuzzFormatFile(f *testing.F) {
+	addFixtureSeeds(f)
+	p := parser.New()
+
+	f.Fuzz(func(t *testing.T, content []byte) {
+		file, err := p.ParseFile(content, "fuzz.tf")
+		if err != nil {
+			return
+		}
+		formatted := p.FormatFile(file)
+
+		file, err = p.ParseFile(formatted, "fuzz.tf")
+		if err != nil {
+			t.Fatalf("formatted output does not parse: %v\ninput:\n%s", err, content)
+		}
+		if again := p.FormatFile(file); string(again) != string(formatted) {
+			t.Fatalf("formatting is not idempotent\nfirst:\n%s\nsecond:\n%s", formatted, again)
+		}
+		assertEquivalent(t, parser.Normalize(content), formatted)
+	})
+}
+
+// addFixtureSeeds adds the inputs of the tests in comprehensive_test.go to
+// the seed corpus
+func addFixtureSeeds(f *testing.F) {
+	file, err := goparser.ParseFile(token.NewFileSet(), "comprehensive_test.go", nil, 0)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		assign, ok := node.(*ast.AssignStmt)
+		if !ok || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+			return true
+		}
+		name, isIdent := assign.Lhs[0].(*ast.Ident)
+		literal, isLiteral := assign.Rhs[0].(*ast.BasicLit)
+		if !isIdent || name.Name != "input" || !isLiteral || literal.Kind != token.STRING {
+			return true
+		}
+		input, err := strconv.Unquote(literal.Value)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add([]byte(input))
+		return true
+	})
+}
+
+// assertEquivalent fails the test unless output holds the same blocks,
+// attributes and expressions as input, disregarding their order and
+// formatting and the order of object keys
+func assertEquivalent(t *testing.T, input, output []byte) {
+	t.Helper()
+
+	before, ok := canonicalConfig(input)
+	if !ok {
+		return
+	}
+	after, ok := canonicalConfig(output)
+	if !ok {
+		t.Fatalf("output does not parse\ninput:\n%s\noutput:\n%s", input, output)
+	}
+	if before != after {
+		t.Fatalf("output is not equivalent to input\ninput:\n%s\noutput:\n%s", input, output)
+	}
+}
+
+// canonicalConfig returns a canonical description of a configuration
+func canonicalConfig(content []byte) (string, bool) {
+	file, diags := hclsyntax.ParseConfig(content, "fuzz.tf", hcl.InitialPos)
+	if diags.HasErrors() {
+		return "", false
+	}
+	return canonicalBody(file.Body.(*hclsyntax.Body)), true
+}
+
+// canonicalBody describes the attributes of a body by name and its blocks in
+// sorted order
+func canonicalBody(body *hclsyntax.Body) string {
+	items := make([]string, 0, len(body.Attributes)+len(body.Blocks))
+	for name, attr := range body.Attributes {
+		items = append(items, name+"="+canonicalExpression(attr.Expr))
+	}
+	for _, block := range body.Blocks {
+		items = append(items, fmt.Sprintf("%s%q{%s}", block.Type, block.Labels, canonicalBody(block.Body)))
+	}
+	slices.Sort(items)
+	return strings.Join(items, ";")
+}
+
+// canonicalExpression describes an expression as a tree of node types and
+// values, with object items in sorted order
+func canonicalExpression(expr hclsyntax.Expression) string {
+	root := &expressionNode{}
+	walker := &expressionWalker{stack: []*expressionNode{root}}
+	hclsyntax.Walk(expr, walker)
+	return root.children[0].String()
+}
+
+type expressionNode struct {
+	label    string
+	object   bool
+	children []*expressionNode
+}
+
+func (n *expressionNode) String() string {
+	children := make([]string, 0, len(n.children))
+	if n.object {
+		// Object children alternate between keys and values
+		for i := 0; i+1 < len(n.children); i += 2 {
+			children = append(children, n.children[i].String()+":"+n.children[i+1].String())
+		}
+		slices.Sort(children)
+	} else {
+		for _, child := range n.children {
+			children = append(children, child.String())
+		}
+	}
+	return n.label + "(" + strings.Join(children, ",") + ")"
+}
+
+// expressionWalker builds the expression tree described by canonicalExpression
+type expressionWalker struct {
+	stack []*expressionNode
+}
+
+func (w *expressionWalker) Enter(node hclsyntax.Node) hcl.Diagnostics {
+	n := &expressionNode{label: expressionLabel(node)}
+	_, n.object = node.(*hclsyntax.ObjectConsExpr)
+	parent := w.stack[len(w.stack)-1]
+	parent.children = append(parent.children, n)
+	w.stack = append(w.stack, n)
+	return nil
+}
+
+func (w *expressionWalker) Exit(node hclsyntax.Node) hcl.Diagnostics {
+	w.stack = w.stack[:len(w.stack)-1]
+	return nil
+}
+
+// expressionLabel describes a node by its type and the values it holds
+// outside its child nodes
+func expressionLabel(node hclsyntax.Node) string {
+	label := fmt.Sprintf("%T", node)
+	switch e := node.(type) {
+	case *hclsyntax.LiteralValueExpr:
+		label += e.Val.GoString()
+	case *hclsyntax.ScopeTraversalExpr:
+		label += canonicalTraversal(e.Traversal)
+	case *hclsyntax.RelativeTraversalExpr:
+		label += canonicalTraversal(e.Traversal)
+	case *hclsyntax.FunctionCallExpr:
+		label += e.Name + strconv.FormatBool(e.ExpandFinal)
+	case *hclsyntax.BinaryOpExpr:
+		label += fmt.Sprintf("%p", e.Op)
+	case *hclsyntax.UnaryOpExpr:
+		label += fmt.Sprintf("%p", e.Op)
+	case *hclsyntax.ForExpr:
+		label += e.KeyVar + "," + e.ValVar + strconv.FormatBool(e.Group)
+	case *hclsyntax.ObjectConsKeyExpr:
+		label += strconv.FormatBool(e.ForceNonLiteral)
+	}
+	return label
+}
+
+// canonicalTraversal describes each step of a traversal
+func canonicalTraversal(traversal hcl.Traversal) string {
+	var b strings.Builder
+	for _, step := range traversal {
+		switch s := step.(type) {
+		case hcl.TraverseRoot:
+			b.WriteString(s.Name)
+		case hcl.TraverseAttr:
+			b.WriteString("." + s.Name)
+		case hcl.TraverseIndex:
+			b.WriteString("[" + s.Key.GoString() + "]")
+		case hcl.TraverseSplat:
+			b.WriteString("[*]")
+		}
+	}
+	return b.String()
+}
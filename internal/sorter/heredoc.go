@@ -0,0 +1,241 @@
+package sorter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+var errDuplicateJSONKey = errors.New("duplicate key in JSON object")
+
+// jsonValue is a JSON value in a heredoc body, kept as its span in the source
+// so that sorting an object moves whole members and leaves the spacing around
+// and between them as written
+type jsonValue struct {
+	Start, End int
+	Members    []jsonMember // members of an object, in source order
+	Elements   []jsonValue  // elements of an array
+	IsObject   bool
+}
+
+// jsonMember is a member of a JSON object: its decoded key, where its key
+// starts and its value
+type jsonMember struct {
+	Key   string
+	Start int
+	Value jsonValue
+}
+
+// sortJSONHeredocs rewrites heredocs holding JSON documents with their object
+// keys sorted. Heredocs containing template sequences, or whose body is not a
+// single JSON value without duplicate keys, are left untouched. It returns nil
+// when nothing was rewritten.
+func (s *Sorter) sortJSONHeredocs(tokens hclwrite.Tokens) hclwrite.Tokens {
+	sorted := false
+	result := make(hclwrite.Tokens, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].Type != hclsyntax.TokenOHeredoc {
+			result = append(result, tokens[i])
+			continue
+		}
+
+		// Heredocs with interpolations contain template tokens and never
+		// reach the closing marker here
+		end := i + 1
+		for end < len(tokens) && tokens[end].Type == hclsyntax.TokenStringLit {
+			end++
+		}
+		if end >= len(tokens) || tokens[end].Type != hclsyntax.TokenCHeredoc {
+			result = append(result, tokens[i])
+			continue
+		}
+
+		body := tokens[i+1 : end].Bytes()
+		document, ok := s.sortJSONDocument(tokens[i].Bytes, body)
+		if !ok || bytes.Equal(document, body) {
+			result = append(result, tokens[i:end]...)
+		} else {
+			result = append(result, tokens[i])
+			for _, line := range bytes.SplitAfter(document, []byte("\n")) {
+				if len(line) > 0 {
+					result = append(result, &hclwrite.Token{
+						Type:  hclsyntax.TokenStringLit,
+						Bytes: line,
+					})
+				}
+			}
+			sorted = true
+		}
+		i = end - 1
+	}
+
+	if !sorted {
+		return nil
+	}
+	return result
+}
+
+// sortJSONDocument returns a heredoc body with the keys of its JSON objects
+// sorted. Only the order of object members changes; the layout of every object
+// and array is kept. Bodies are considered when their marker is JSON or POLICY
+// or when they start like a JSON object or array.
+func (s *Sorter) sortJSONDocument(opener, body []byte) ([]byte, bool) {
+	marker := strings.TrimSpace(strings.TrimLeft(string(opener), "<-"))
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, false
+	}
+	if marker != "JSON" && marker != "POLICY" && trimmed[0] != '{' && trimmed[0] != '[' {
+		return nil, false
+	}
+	if bytes.Contains(body, []byte("${")) || bytes.Contains(body, []byte("%{")) {
+		return nil, false
+	}
+	if !json.Valid(body) {
+		return nil, false
+	}
+
+	scanner := &jsonScanner{src: body}
+	scanner.skipSpace()
+	value, err := scanner.value()
+	if err != nil {
+		return nil, false
+	}
+
+	var document bytes.Buffer
+	document.Write(body[:value.Start])
+	s.writeJSONValue(&document, body, value)
+	document.Write(body[value.End:])
+	return document.Bytes(), true
+}
+
+// jsonScanner reads the spans of the values in a valid JSON document
+type jsonScanner struct {
+	src []byte
+	pos int
+}
+
+func (sc *jsonScanner) skipSpace() {
+	for sc.pos < len(sc.src) && strings.IndexByte(" \t\r\n", sc.src[sc.pos]) >= 0 {
+		sc.pos++
+	}
+}
+
+// value reads the value starting at the current position, rejecting objects
+// with duplicate keys
+func (sc *jsonScanner) value() (jsonValue, error) {
+	value := jsonValue{Start: sc.pos}
+	switch sc.src[sc.pos] {
+	case '{':
+		value.IsObject = true
+		seen := make(map[string]bool)
+		sc.pos++
+		sc.skipSpace()
+		for sc.src[sc.pos] != '}' {
+			member := jsonMember{Start: sc.pos}
+			sc.skipString()
+			if err := json.Unmarshal(sc.src[member.Start:sc.pos], &member.Key); err != nil {
+				return value, err
+			}
+			if seen[member.Key] {
+				return value, errDuplicateJSONKey
+			}
+			seen[member.Key] = true
+
+			sc.skipSpace()
+			sc.pos++ // colon
+			sc.skipSpace()
+			memberValue, err := sc.value()
+			if err != nil {
+				return value, err
+			}
+			member.Value = memberValue
+			value.Members = append(value.Members, member)
+			sc.skipSeparator()
+		}
+		sc.pos++
+	case '[':
+		sc.pos++
+		sc.skipSpace()
+		for sc.src[sc.pos] != ']' {
+			element, err := sc.value()
+			if err != nil {
+				return value, err
+			}
+			value.Elements = append(value.Elements, element)
+			sc.skipSeparator()
+		}
+		sc.pos++
+	case '"':
+		sc.skipString()
+	default:
+		for sc.pos < len(sc.src) && strings.IndexByte(",]} \t\r\n", sc.src[sc.pos]) < 0 {
+			sc.pos++
+		}
+	}
+	value.End = sc.pos
+	return value, nil
+}
+
+// skipString moves past the string starting at the current position
+func (sc *jsonScanner) skipString() {
+	for sc.pos++; sc.src[sc.pos] != '"'; sc.pos++ {
+		if sc.src[sc.pos] == '\\' {
+			sc.pos++
+		}
+	}
+	sc.pos++
+}
+
+// skipSeparator moves past the whitespace and comma following a member or
+// element
+func (sc *jsonScanner) skipSeparator() {
+	sc.skipSpace()
+	if sc.src[sc.pos] == ',' {
+		sc.pos++
+		sc.skipSpace()
+	}
+}
+
+// writeJSONValue writes a JSON value from src with object members ordered by
+// the encoded key order and then collation. Each member takes the place of
+// the member at its new position, so the text between members stays put.
+func (s *Sorter) writeJSONValue(buf *bytes.Buffer, src []byte, value jsonValue) {
+	switch {
+	case value.IsObject && len(value.Members) > 0:
+		members := append([]jsonMember(nil), value.Members...)
+		sort.SliceStable(members, func(i, j int) bool {
+			return s.compareKeys(members[i].Key, members[j].Key, s.config.EncodedKeyOrder)
+		})
+
+		buf.Write(src[value.Start:value.Members[0].Start])
+		for i, member := range members {
+			buf.Write(src[member.Start:member.Value.Start])
+			s.writeJSONValue(buf, src, member.Value)
+			next := value.End - 1
+			if i+1 < len(value.Members) {
+				next = value.Members[i+1].Start
+			}
+			buf.Write(src[value.Members[i].Value.End:next])
+		}
+		buf.WriteByte('}')
+	case len(value.Elements) > 0:
+		buf.Write(src[value.Start:value.Elements[0].Start])
+		for i, element := range value.Elements {
+			s.writeJSONValue(buf, src, element)
+			next := value.End - 1
+			if i+1 < len(value.Elements) {
+				next = value.Elements[i+1].Start
+			}
+			buf.Write(src[element.End:next])
+		}
+		buf.WriteByte(']')
+	default:
+		buf.Write(src[value.Start:value.End])
+	}
+}
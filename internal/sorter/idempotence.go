@@ -0,0 +1,42 @@
+package sorter
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/maxexcloo/tofusort/internal/parser"
+)
+
+// NotIdempotentError reports sorted output that changes when it is sorted
+// again.
+type NotIdempotentError struct {
+	Filename string
+	First    []byte // output of the first pass
+	Second   []byte // output of sorting the first pass again
+}
+
+func (e *NotIdempotentError) Error() string {
+	return fmt.Sprintf("%s: sorting is not idempotent, a second pass changes the output", e.Filename)
+}
+
+// CheckIdempotent sorts the formatted output of a first pass again and
+// returns a *NotIdempotentError if the second pass changes it. Output that
+// no longer parses is reported with the parse error.
+func (s *Sorter) CheckIdempotent(p *parser.Parser, sorted []byte, filename string) error {
+	file, err := p.ParseFile(sorted, filename)
+	if err != nil {
+		return fmt.Errorf("sorted output does not parse: %w", err)
+	}
+
+	// The second pass is not reported to the observer
+	observer := s.observer
+	s.observer = nil
+	s.SortFile(file)
+	s.observer = observer
+
+	second := p.FormatFile(file)
+	if !bytes.Equal(sorted, second) {
+		return &NotIdempotentError{Filename: filename, First: sorted, Second: second}
+	}
+	return nil
+}
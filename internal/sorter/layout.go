@@ -0,0 +1,193 @@
+package sorter
+
+import (
+	"sort"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// fileLayout is the order of the top-level blocks of a file
+type fileLayout struct {
+	blocks []BlockInfo
+
+	// rules describe why each block follows the one before it, once a rule
+	// has ordered the blocks
+	rules map[*hclwrite.Block]string
+
+	// spacing separates groups of blocks with blank lines
+	spacing bool
+}
+
+// rule describes why a block follows the one before it
+func (l *fileLayout) rule(block *hclwrite.Block) string {
+	if rule, exists := l.rules[block]; exists {
+		return rule
+	}
+	return "blocks keep authored order"
+}
+
+// bodyGroup is a group of items in a body layout. Groups are written in
+// order, each set apart from the one before it by a blank line.
+type bodyGroup int
+
+const (
+	groupFirst      bodyGroup = iota // pinned-first arguments, early meta-arguments, or locals in dependency order
+	groupAttributes                  // attributes, or single-line attributes once sorted
+	groupBlocks                      // nested blocks
+	groupSequential                  // nested blocks that take effect in authored order
+	groupMultiLine                   // multi-line attributes once sorted
+	groupLate                        // late meta-arguments
+	groupLifecycle                   // lifecycle blocks
+	groupLast                        // pinned-last arguments
+	groupCount
+)
+
+// bodyLayout is the order in which the attributes and nested blocks of a body
+// are written. It starts with the attributes in authored order followed by the
+// nested blocks, and rules move items between groups and sort them.
+type bodyLayout struct {
+	blockType string        // type of the block, empty for the body of a file
+	order     ArgumentOrder // argument order configured for the block type
+	groups    [groupCount][]bodyItem
+
+	// splitMultiLine writes the single-line attributes of a group before
+	// its multi-line ones
+	splitMultiLine bool
+
+	// separateFirst sets the first group apart from the single-line
+	// attributes that follow it, which are otherwise written directly after it
+	separateFirst bool
+
+	// spacing separates groups, nested blocks and multi-line attributes with
+	// blank lines
+	spacing bool
+}
+
+// newBodyLayout lays out attributes in authored order followed by nested
+// blocks, with the blocks that take effect in order last
+func newBodyLayout(blockType string, order ArgumentOrder, attrs map[string]AttrInfo, blocks []*hclwrite.Block) *bodyLayout {
+	l := &bodyLayout{blockType: blockType, order: order}
+
+	attrInfos := make([]AttrInfo, 0, len(attrs))
+	for _, attr := range attrs {
+		attrInfos = append(attrInfos, attr)
+	}
+	sort.Slice(attrInfos, func(i, j int) bool {
+		return attrInfos[i].Pos < attrInfos[j].Pos
+	})
+	for i := range attrInfos {
+		l.groups[groupAttributes] = append(l.groups[groupAttributes], bodyItem{Attr: &attrInfos[i], RuleID: RuleAttributeOrder, Rule: "attributes keep authored order"})
+	}
+
+	for _, block := range blocks {
+		if sequentialBlockTypes[block.Type()] {
+			l.groups[groupSequential] = append(l.groups[groupSequential], bodyItem{Block: block, RuleID: RuleNestedBlocks, Rule: block.Type() + " blocks keep authored order"})
+		} else {
+			l.groups[groupBlocks] = append(l.groups[groupBlocks], bodyItem{Block: block, RuleID: nestedBlockRule(block), Rule: "nested blocks keep authored order"})
+		}
+	}
+
+	return l
+}
+
+// hasBlocks reports whether the body has nested blocks
+func (l *bodyLayout) hasBlocks() bool {
+	for _, items := range l.groups {
+		for _, item := range items {
+			if item.Block != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// takeArguments removes the attributes and nested blocks named in an argument
+// list from their groups and returns them in list order. Blocks sharing a type
+// keep their authored order.
+func (l *bodyLayout) takeArguments(names []string, rule string) []bodyItem {
+	var taken []bodyItem
+	for _, name := range names {
+		for group := range l.groups {
+			items := l.groups[group][:0]
+			for _, item := range l.groups[group] {
+				if item.name() == name {
+					item.RuleID, item.Rule = RuleAttributeOrder, rule
+					taken = append(taken, item)
+				} else {
+					items = append(items, item)
+				}
+			}
+			l.groups[group] = items
+		}
+	}
+	return taken
+}
+
+// name returns the name of an attribute or the type of a block
+func (item bodyItem) name() string {
+	if item.Block != nil {
+		return item.Block.Type()
+	}
+	return item.Attr.Name
+}
+
+// spaced reports whether an item is set apart from its neighbours by blank
+// lines: nested blocks and multi-line attributes are
+func (item bodyItem) spaced() bool {
+	return item.Block != nil || item.Attr.IsMultiLine
+}
+
+// separated reports whether a blank line is written between an item of group
+// prevGroup and the item of group that follows it
+func (l *bodyLayout) separated(prev bodyItem, prevGroup bodyGroup, item bodyItem, group bodyGroup) bool {
+	switch {
+	case prev.spaced() || item.spaced():
+		return true
+	case prevGroup == group:
+		return false
+	case prevGroup == groupFirst && group == groupAttributes:
+		return l.separateFirst
+	default:
+		return true
+	}
+}
+
+// items returns the items of a group in the order they are written
+func (l *bodyLayout) items(group bodyGroup) []bodyItem {
+	items := l.groups[group]
+	if !l.splitMultiLine || group == groupFirst || group == groupLast {
+		return items
+	}
+
+	ordered := make([]bodyItem, 0, len(items))
+	for _, item := range items {
+		if item.Attr != nil && !item.Attr.IsMultiLine {
+			ordered = append(ordered, item)
+		}
+	}
+	for _, item := range items {
+		if item.Block != nil || item.Attr.IsMultiLine {
+			ordered = append(ordered, item)
+		}
+	}
+	return ordered
+}
+
+// objectLayout is the order of the entries of an object constructor
+type objectLayout struct {
+	object  sortableObject
+	entries []ObjectEntry
+
+	// sorted records that a rule ordered the entries, so the object is
+	// rebuilt rather than left as written
+	sorted bool
+
+	// grouped records that single-line entries were placed before
+	// multi-line ones
+	grouped bool
+
+	// spacing separates the groups of entries and multi-line entries with
+	// blank lines
+	spacing bool
+}
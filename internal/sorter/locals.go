@@ -0,0 +1,195 @@
+package sorter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// orderLocalsByDependency orders locals so that each local follows the locals
+// it references, breaking ties in collation order. Reference cycles are
+// reported as warnings and broken at their first local in collation order.
+func (s *Sorter) orderLocalsByDependency(attrs map[string]AttrInfo) []bodyItem {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return s.less(names[i], names[j])
+	})
+
+	dependencies := make(map[string][]string, len(names))
+	for _, name := range names {
+		for _, reference := range s.localReferences(attrs[name].Expr) {
+			if _, exists := attrs[reference]; exists {
+				dependencies[name] = append(dependencies[name], reference)
+			}
+		}
+	}
+
+	emitted := make(map[string]bool, len(names))
+	items := make([]bodyItem, 0, len(names))
+	for len(items) < len(names) {
+		next := ""
+		for _, name := range names {
+			if !emitted[name] && s.dependenciesEmitted(dependencies[name], emitted) {
+				next = name
+				break
+			}
+		}
+
+		if next == "" {
+			cycle := s.findLocalsCycle(names, dependencies, emitted)
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagWarning,
+				Summary:  "Reference cycle in locals",
+				Detail:   fmt.Sprintf("The locals reference each other in a cycle: %s.", formatLocalsCycle(cycle)),
+			}
+			if rng := attrs[cycle[0]].rng; rng.Start.Line > 0 {
+				diag.Subject = &rng
+			}
+			s.diags = append(s.diags, diag)
+
+			next = cycle[0]
+			for _, name := range cycle {
+				if s.less(name, next) {
+					next = name
+				}
+			}
+		}
+
+		emitted[next] = true
+		attr := attrs[next]
+		items = append(items, bodyItem{Attr: &attr, RuleID: RuleAttributeOrder, Rule: "locals dependency order"})
+	}
+
+	return items
+}
+
+func (s *Sorter) dependenciesEmitted(dependencies []string, emitted map[string]bool) bool {
+	for _, dependency := range dependencies {
+		if !emitted[dependency] {
+			return false
+		}
+	}
+	return true
+}
+
+// findLocalsCycle follows unresolved references from the first pending local
+// until a local repeats, returning the locals that form the cycle
+func (s *Sorter) findLocalsCycle(names []string, dependencies map[string][]string, emitted map[string]bool) []string {
+	var start string
+	for _, name := range names {
+		if !emitted[name] {
+			start = name
+			break
+		}
+	}
+
+	var path []string
+	visited := make(map[string]int)
+	current := start
+	for {
+		if index, seen := visited[current]; seen {
+			return path[index:]
+		}
+		visited[current] = len(path)
+		path = append(path, current)
+
+		for _, dependency := range dependencies[current] {
+			if !emitted[dependency] {
+				current = dependency
+				break
+			}
+		}
+	}
+}
+
+func formatLocalsCycle(cycle []string) string {
+	references := make([]string, 0, len(cycle)+1)
+	for _, name := range cycle {
+		references = append(references, "local."+name)
+	}
+	references = append(references, "local."+cycle[0])
+	return strings.Join(references, " -> ")
+}
+
+// localReferences returns the names of locals referenced by an expression
+func (s *Sorter) localReferences(expr *hclwrite.Expression) []string {
+	src := expr.BuildTokens(nil).Bytes()
+	syntaxExpr, diags := hclsyntax.ParseExpression(src, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil
+	}
+
+	var references []string
+	for _, traversal := range syntaxExpr.Variables() {
+		if traversal.RootName() != "local" || len(traversal) < 2 {
+			continue
+		}
+		if step, ok := traversal[1].(hcl.TraverseAttr); ok {
+			references = append(references, step.Name)
+		}
+	}
+	return references
+}
+
+// attributeRange returns the source range of an attribute from its name to the
+// end of its value, or an empty range when token positions are not known
+func (s *Sorter) attributeRange(attr *hclwrite.Attribute) hcl.Range {
+	if s.positions == nil {
+		return hcl.Range{}
+	}
+
+	var first, last *hclwrite.Token
+	for _, token := range attr.BuildTokens(nil) {
+		if token.Type == hclsyntax.TokenComment || token.Type == hclsyntax.TokenNewline {
+			continue
+		}
+		if first == nil {
+			first = token
+		}
+		last = token
+	}
+
+	start, foundStart := s.positions[first]
+	end, foundEnd := s.positions[last]
+	if !foundStart || !foundEnd {
+		return hcl.Range{}
+	}
+	return hcl.Range{Start: start, End: advancePos(end, last.Bytes)}
+}
+
+// tokenPositions maps each token to the position it starts at
+func tokenPositions(tokens hclwrite.Tokens) map[*hclwrite.Token]hcl.Pos {
+	positions := make(map[*hclwrite.Token]hcl.Pos, len(tokens))
+	pos := hcl.InitialPos
+	for _, token := range tokens {
+		pos.Byte += token.SpacesBefore
+		pos.Column += token.SpacesBefore
+		positions[token] = pos
+		pos = advancePos(pos, token.Bytes)
+	}
+	return positions
+}
+
+// advancePos returns the position following src when it starts at pos
+func advancePos(pos hcl.Pos, src []byte) hcl.Pos {
+	for len(src) > 0 {
+		r, size := utf8.DecodeRune(src)
+		src = src[size:]
+		pos.Byte += size
+		if r == '\n' {
+			pos.Line++
+			pos.Column = 1
+		} else {
+			pos.Column++
+		}
+	}
+	return pos
+}
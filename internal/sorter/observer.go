@@ -0,0 +1,210 @@
+package sorter
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// Kinds of element reported in a Change
+const (
+	KindBlock     = "block"
+	KindAttribute = "attribute"
+	KindObjectKey = "object key"
+)
+
+// Change records where the sorter placed an element and the rule that placed
+// it there. Lines are 1-based; NewLine refers to the sorted file before
+// formatting, which only removes blank lines.
+type Change struct {
+	Kind    string
+	Address string
+	OldLine int
+	NewLine int
+	RuleID  string
+	Rule    string
+}
+
+// Observer receives a Change for each block, attribute and object key written
+// while sorting a file, in the order the elements appear in the sorted file.
+type Observer interface {
+	Observe(change Change)
+}
+
+// ObserverFunc adapts a function to the Observer interface.
+type ObserverFunc func(change Change)
+
+// Observe calls f(change).
+func (f ObserverFunc) Observe(change Change) {
+	f(change)
+}
+
+// SetObserver registers an observer notified of the changes made by SortFile.
+// A nil observer disables recording.
+func (s *Sorter) SetObserver(observer Observer) {
+	s.observer = observer
+}
+
+// placement is an element written by the sorter, identified by its first
+// token so that its line can be found before and after sorting
+type placement struct {
+	token   *hclwrite.Token
+	kind    string
+	address string
+	ruleID  string
+	rule    string
+}
+
+func (s *Sorter) record(tokens hclwrite.Tokens, kind, address, ruleID, rule string) {
+	if s.observer == nil {
+		return
+	}
+	for _, token := range tokens {
+		if !isLineEndToken(token) {
+			s.placements = append(s.placements, placement{token: token, kind: kind, address: address, ruleID: ruleID, rule: rule})
+			return
+		}
+	}
+}
+
+func (s *Sorter) recordBlock(block *hclwrite.Block, address, ruleID, rule string) {
+	s.record(block.BuildTokens(nil), KindBlock, address, ruleID, rule)
+}
+
+func (s *Sorter) recordAttribute(attr AttrInfo, parent, ruleID, rule string) {
+	s.record(attr.Expr.BuildTokens(nil), KindAttribute, joinAddress(parent, attr.Name), ruleID, rule)
+}
+
+// notifyObserver reports the recorded placements with their lines in the file
+// before and after sorting
+func (s *Sorter) notifyObserver(oldLines map[*hclwrite.Token]int, file *hclwrite.File) {
+	newLines := tokenLines(file.BuildTokens(nil))
+
+	changes := make([]Change, 0, len(s.placements))
+	for _, p := range s.placements {
+		oldLine, foundOld := oldLines[p.token]
+		newLine, foundNew := newLines[p.token]
+		if !foundOld || !foundNew {
+			continue
+		}
+		changes = append(changes, Change{
+			Kind:    p.kind,
+			Address: p.address,
+			OldLine: oldLine,
+			NewLine: newLine,
+			RuleID:  p.ruleID,
+			Rule:    p.rule,
+		})
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].NewLine < changes[j].NewLine
+	})
+	for _, change := range changes {
+		s.observer.Observe(change)
+	}
+}
+
+// tokenLines maps each token to the line it starts on
+func tokenLines(tokens hclwrite.Tokens) map[*hclwrite.Token]int {
+	lines := make(map[*hclwrite.Token]int, len(tokens))
+	line := 1
+	for _, token := range tokens {
+		lines[token] = line
+		line += bytes.Count(token.Bytes, []byte("\n"))
+	}
+	return lines
+}
+
+// blockAddress returns the address of a block within its parent. Top-level
+// blocks are addressed as Terraform does, so resources are addressed by their
+// type and name and variables under var; other blocks are addressed by their
+// type followed by their labels.
+func blockAddress(parent string, block *hclwrite.Block) string {
+	labels := block.Labels()
+	address := block.Type()
+	if parent == "" {
+		switch {
+		case address == "resource" && len(labels) > 0:
+			return strings.Join(labels, ".")
+		case address == "variable":
+			address = "var"
+		}
+	}
+	for _, label := range labels {
+		address += "." + label
+	}
+	return joinAddress(parent, address)
+}
+
+// bodyAddress returns the address the contents of a top-level block are
+// recorded under, which is local for the values of a locals block
+func bodyAddress(address string, block *hclwrite.Block) string {
+	if block.Type() == "locals" {
+		return "local"
+	}
+	return address
+}
+
+func joinAddress(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// blockRule describes why a top-level block follows the block before it once
+// the blocks are sorted
+func (s *Sorter) blockRule(prev *BlockInfo, block BlockInfo) string {
+	switch {
+	case prev == nil:
+		return fmt.Sprintf("block-type order: %s first", block.Type)
+	case prev.Type != block.Type:
+		return fmt.Sprintf("block-type order: %s before %s", prev.Type, block.Type)
+	case block.Type == "moved" && s.config.MovedOrder == MovedOrderTopological:
+		return "moved chain order"
+	case s.config.LabelOrder == LabelOrderAuthored:
+		return "blocks of a type keep authored order"
+	case block.Type == "import":
+		return "import blocks ordered by to address"
+	case block.Type == "removed":
+		return "removed blocks ordered by from address"
+	case block.Type == "moved":
+		return "moved blocks keep authored order"
+	default:
+		return s.collationRule("label")
+	}
+}
+
+// nestedBlockRule returns the rule that orders a nested block among its
+// siblings
+func nestedBlockRule(block *hclwrite.Block) string {
+	switch block.Type() {
+	case "dynamic":
+		return RuleDynamicBlocks
+	case "validation":
+		return RuleValidationBlocks
+	}
+	return RuleNestedBlocks
+}
+
+// collationRule names ordering by the configured collation
+func (s *Sorter) collationRule(what string) string {
+	return fmt.Sprintf("%s order (%s)", what, s.config.Collation)
+}
+
+// objectKeyRule describes why an object entry is placed where it is
+func (s *Sorter) objectKeyRule(entry ObjectEntry, keyOrder []string, grouped bool) string {
+	for _, key := range keyOrder {
+		if key == entry.Key {
+			return "pinned object key " + key
+		}
+	}
+	if grouped && entry.MultiLine {
+		return "multi-line object entry group"
+	}
+	return s.collationRule("key")
+}
@@ -0,0 +1,330 @@
+package sorter
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// Rule IDs, used to enable and disable parts of the sorting behaviour
+const (
+	RuleBlockOrder       = "block-order"
+	RuleAttributeOrder   = "attribute-order"
+	RuleMetaArguments    = "meta-arguments"
+	RuleObjectKeys       = "object-keys"
+	RuleNestedBlocks     = "nested-blocks"
+	RuleDynamicBlocks    = "dynamic-blocks"
+	RuleValidationBlocks = "validation-blocks"
+	RuleSpacing          = "spacing"
+)
+
+// scope is the set of layouts a rule arranges
+type scope uint8
+
+const (
+	fileScope   scope = 1 << iota // the top-level blocks of a file
+	bodyScope                     // the attributes and nested blocks of a body
+	objectScope                   // the entries of an object constructor
+)
+
+// Rule is a named part of the sorting behaviour. The sorter lays out the
+// top-level blocks of a file, each body and each object constructor in
+// authored order, and every enabled rule then arranges the elements it is
+// responsible for, in the order Rules lists them. A disabled rule leaves those
+// elements in authored order.
+type Rule interface {
+	// ID is the name used to select the rule in flags and configuration.
+	ID() string
+	// Description summarises what the rule orders.
+	Description() string
+
+	// scope reports the layouts the rule arranges; only the arrange methods
+	// for those layouts are called.
+	scope() scope
+	arrangeFile(s *Sorter, l *fileLayout)
+	arrangeBody(s *Sorter, l *bodyLayout)
+	arrangeObject(s *Sorter, l *objectLayout)
+}
+
+// rule names a rule and arranges nothing. Rules embed it and implement the
+// arrange methods of their scope.
+type rule struct {
+	id          string
+	description string
+	scopes      scope
+}
+
+func (r rule) ID() string          { return r.id }
+func (r rule) Description() string { return r.description }
+func (r rule) scope() scope        { return r.scopes }
+
+func (rule) arrangeFile(*Sorter, *fileLayout)     {}
+func (rule) arrangeBody(*Sorter, *bodyLayout)     {}
+func (rule) arrangeObject(*Sorter, *objectLayout) {}
+
+var rules = []Rule{
+	blockOrderRule{rule{RuleBlockOrder, "Top-level blocks by type, then by label", fileScope}},
+	attributeOrderRule{rule{RuleAttributeOrder, "Attributes by name, single-line before multi-line, and argument_order lists", bodyScope}},
+	metaArgumentsRule{rule{RuleMetaArguments, "count, for_each and provider first; depends_on and lifecycle last", bodyScope}},
+	objectKeysRule{rule{RuleObjectKeys, "Keys of object constructors in attribute values", objectScope}},
+	nestedBlocksRule{rule{RuleNestedBlocks, "Nested blocks by type", bodyScope}},
+	dynamicBlocksRule{rule{RuleDynamicBlocks, "dynamic blocks by label, content key and for_each", bodyScope}},
+	validationBlocksRule{rule{RuleValidationBlocks, "validation blocks by error_message", bodyScope}},
+	spacingRule{rule{RuleSpacing, "Blank lines between groups of attributes and blocks", fileScope | bodyScope | objectScope}},
+}
+
+// Rules returns the built-in rules.
+func Rules() []Rule {
+	return append([]Rule(nil), rules...)
+}
+
+// RuleIDs returns the IDs of the built-in rules.
+func RuleIDs() []string {
+	ids := make([]string, 0, len(rules))
+	for _, r := range rules {
+		ids = append(ids, r.ID())
+	}
+	return ids
+}
+
+// enabledRules returns the rules that are not disabled, keyed by each scope
+// they arrange
+func enabledRules(disabled []string) map[scope][]Rule {
+	enabled := make(map[scope][]Rule)
+	for _, r := range rules {
+		if slices.Contains(disabled, r.ID()) {
+			continue
+		}
+		for _, sc := range []scope{fileScope, bodyScope, objectScope} {
+			if r.scope()&sc != 0 {
+				enabled[sc] = append(enabled[sc], r)
+			}
+		}
+	}
+	return enabled
+}
+
+// blockOrderRule orders top-level blocks by type and then by label, import
+// address, removed address or moved chain
+type blockOrderRule struct{ rule }
+
+func (blockOrderRule) arrangeFile(s *Sorter, l *fileLayout) {
+	sort.SliceStable(l.blocks, func(i, j int) bool {
+		if s.config.LabelOrder == LabelOrderAuthored {
+			return s.blockTypeLess(l.blocks[i].Type, l.blocks[j].Type)
+		}
+		return s.compareBlocks(l.blocks[i], l.blocks[j])
+	})
+	if s.config.MovedOrder == MovedOrderTopological {
+		s.orderMovedBlocks(l.blocks)
+	}
+
+	l.rules = make(map[*hclwrite.Block]string, len(l.blocks))
+	for i, block := range l.blocks {
+		var prev *BlockInfo
+		if i > 0 {
+			prev = &l.blocks[i-1]
+		}
+		l.rules[block.Block] = s.blockRule(prev, block)
+	}
+}
+
+// attributeOrderRule places pinned arguments first and last, and orders the
+// other attributes by name with multi-line attributes after the nested
+// blocks. Locals ordered by dependency are placed in dependency order instead.
+type attributeOrderRule struct{ rule }
+
+func (attributeOrderRule) arrangeBody(s *Sorter, l *bodyLayout) {
+	if l.blockType == "locals" && s.config.LocalsOrder == LocalsOrderDependency && !l.hasBlocks() {
+		attrs := make(map[string]AttrInfo, len(l.groups[groupAttributes]))
+		for _, item := range l.groups[groupAttributes] {
+			attrs[item.Attr.Name] = *item.Attr
+		}
+		l.groups[groupAttributes] = nil
+		l.groups[groupFirst] = s.orderLocalsByDependency(attrs)
+		return
+	}
+
+	first := l.takeArguments(l.order.First, "pinned first argument of "+l.blockType)
+	last := l.takeArguments(l.order.Last, "pinned last argument of "+l.blockType)
+	l.groups[groupFirst] = append(first, l.groups[groupFirst]...)
+	l.groups[groupLast] = append(l.groups[groupLast], last...)
+
+	var singleLine, multiLine []bodyItem
+	for _, item := range l.groups[groupAttributes] {
+		if item.Attr.IsMultiLine {
+			item.Rule = "multi-line attribute group"
+			multiLine = append(multiLine, item)
+		} else {
+			item.Rule = "single-line attribute group"
+			singleLine = append(singleLine, item)
+		}
+	}
+	for _, items := range [][]bodyItem{singleLine, multiLine} {
+		sort.Slice(items, func(i, j int) bool {
+			return s.less(items[i].Attr.Name, items[j].Attr.Name)
+		})
+	}
+	l.groups[groupAttributes], l.groups[groupMultiLine] = singleLine, multiLine
+	l.splitMultiLine = true
+}
+
+// metaArgumentsRule places count, for_each and provider first, and depends_on
+// and lifecycle last
+type metaArgumentsRule struct{ rule }
+
+func (metaArgumentsRule) arrangeBody(s *Sorter, l *bodyLayout) {
+	// The body of a file assigns variables rather than meta-arguments
+	if l.blockType == "" {
+		return
+	}
+
+	var early, late []bodyItem
+	for _, group := range []bodyGroup{groupAttributes, groupMultiLine} {
+		l.groups[group] = slices.DeleteFunc(l.groups[group], func(item bodyItem) bool {
+			name := item.Attr.Name
+			switch {
+			case isEarlyAttribute(name):
+				item.RuleID, item.Rule = RuleMetaArguments, "early meta-argument "+name
+				early = append(early, item)
+			case isLateAttribute(name):
+				item.RuleID, item.Rule = RuleMetaArguments, "late meta-argument "+name
+				late = append(late, item)
+			default:
+				return false
+			}
+			return true
+		})
+	}
+	sort.Slice(early, func(i, j int) bool {
+		return s.compareEarlyAttributes(early[i].Attr.Name, early[j].Attr.Name)
+	})
+	sort.Slice(late, func(i, j int) bool {
+		return s.compareLateAttributes(late[i].Attr.Name, late[j].Attr.Name)
+	})
+	l.groups[groupFirst] = append(l.groups[groupFirst], early...)
+	l.groups[groupLate] = append(l.groups[groupLate], late...)
+
+	l.groups[groupBlocks] = slices.DeleteFunc(l.groups[groupBlocks], func(item bodyItem) bool {
+		if item.Block.Type() != "lifecycle" {
+			return false
+		}
+		item.RuleID, item.Rule = RuleMetaArguments, "lifecycle block last"
+		l.groups[groupLifecycle] = append(l.groups[groupLifecycle], item)
+		return true
+	})
+
+	// Meta-arguments are set apart from the attributes that follow them,
+	// including those pinned first
+	l.separateFirst = slices.ContainsFunc(l.groups[groupFirst], func(item bodyItem) bool {
+		return item.Attr != nil && isEarlyAttribute(item.Attr.Name)
+	})
+}
+
+// objectKeysRule orders the keys of object constructors, placing pinned keys
+// first and multi-line entries after single-line ones
+type objectKeysRule struct{ rule }
+
+func (objectKeysRule) arrangeObject(s *Sorter, l *objectLayout) {
+	keyOrder := l.object.KeyOrder
+
+	var singleLine, multiLine []ObjectEntry
+	for _, entry := range l.entries {
+		if entry.MultiLine {
+			multiLine = append(multiLine, entry)
+		} else {
+			singleLine = append(singleLine, entry)
+		}
+	}
+	for _, entries := range [][]ObjectEntry{singleLine, multiLine} {
+		sort.Slice(entries, func(i, j int) bool {
+			return s.compareKeys(entries[i].Key, entries[j].Key, keyOrder)
+		})
+	}
+
+	l.entries = append(singleLine, multiLine...)
+	l.grouped = len(singleLine) > 0 && len(multiLine) > 0
+	l.sorted = true
+	for _, entry := range l.entries {
+		s.record(hclwrite.Tokens{entry.KeyToken}, KindObjectKey, joinAddress(l.object.Address, entry.Key), RuleObjectKeys, s.objectKeyRule(entry, keyOrder, l.grouped))
+	}
+}
+
+// nestedBlocksRule orders nested blocks by type
+type nestedBlocksRule struct{ rule }
+
+func (nestedBlocksRule) arrangeBody(s *Sorter, l *bodyLayout) {
+	items := l.groups[groupBlocks]
+	sort.SliceStable(items, func(i, j int) bool {
+		return s.blockTypeLess(items[i].Block.Type(), items[j].Block.Type())
+	})
+	for i := range items {
+		if items[i].RuleID == RuleNestedBlocks {
+			items[i].Rule = "nested block order"
+		}
+	}
+}
+
+// dynamicBlocksRule orders dynamic blocks by label, then by the id, label or
+// name in their content, then by for_each
+type dynamicBlocksRule struct{ rule }
+
+func (dynamicBlocksRule) arrangeBody(s *Sorter, l *bodyLayout) {
+	sortBlocksOfType(l.groups[groupBlocks], "dynamic", s.dynamicBlockLess, "dynamic block order")
+}
+
+// validationBlocksRule orders validation blocks by error_message
+type validationBlocksRule struct{ rule }
+
+func (validationBlocksRule) arrangeBody(s *Sorter, l *bodyLayout) {
+	sortBlocksOfType(l.groups[groupBlocks], "validation", func(a, b *hclwrite.Block) bool {
+		return s.getValidationErrorMessage(a) < s.getValidationErrorMessage(b)
+	}, "validation block order")
+}
+
+// spacingRule separates groups of blocks, attributes and object entries with
+// blank lines
+type spacingRule struct{ rule }
+
+func (spacingRule) arrangeFile(_ *Sorter, l *fileLayout)     { l.spacing = true }
+func (spacingRule) arrangeBody(_ *Sorter, l *bodyLayout)     { l.spacing = true }
+func (spacingRule) arrangeObject(_ *Sorter, l *objectLayout) { l.spacing = true }
+
+// sortBlocksOfType stably sorts the blocks of a type among the items of a
+// group, leaving items of other types in place
+func sortBlocksOfType(items []bodyItem, blockType string, less func(a, b *hclwrite.Block) bool, rule string) {
+	var indexes []int
+	var blocks []bodyItem
+	for i, item := range items {
+		if item.Block != nil && item.Block.Type() == blockType {
+			indexes = append(indexes, i)
+			blocks = append(blocks, item)
+		}
+	}
+
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return less(blocks[i].Block, blocks[j].Block)
+	})
+	for i, index := range indexes {
+		blocks[i].Rule = rule
+		items[index] = blocks[i]
+	}
+}
+
+// dynamicBlockLess orders dynamic blocks by label, then by the id, label or
+// name of their content, ignoring case, then by their for_each expression
+func (s *Sorter) dynamicBlockLess(a, b *hclwrite.Block) bool {
+	labelA := strings.ToLower(s.getDynamicBlockLabel(a))
+	labelB := strings.ToLower(s.getDynamicBlockLabel(b))
+	if labelA != labelB {
+		return labelA < labelB
+	}
+	contentKeyA := strings.ToLower(s.getDynamicContentSortKey(a))
+	contentKeyB := strings.ToLower(s.getDynamicContentSortKey(b))
+	if contentKeyA != contentKeyB {
+		return contentKeyA < contentKeyB
+	}
+	return s.getDynamicForEachContent(a) < s.getDynamicForEachContent(b)
+}
@@ -0,0 +1,102 @@
+package sorter
+
+import (
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// bodySection is a run of attributes and nested blocks of a body that are
+// sorted among themselves when sections are enabled
+type bodySection struct {
+	// Header holds the blank lines and comments written before the section
+	Header hclwrite.Tokens
+	Attrs  []string
+	Blocks []*hclwrite.Block
+}
+
+// attrInfos returns the entries of attrInfos for the attributes in the section
+func (sec bodySection) attrInfos(attrInfos map[string]AttrInfo) map[string]AttrInfo {
+	subset := make(map[string]AttrInfo, len(sec.Attrs))
+	for _, name := range sec.Attrs {
+		subset[name] = attrInfos[name]
+	}
+	return subset
+}
+
+// bodySections splits a body into sections in authored order. A blank line or
+// a standalone comment line before an item starts a new section, and becomes
+// part of its header along with the comments leading the item. A last section
+// without items holds anything written after the last item.
+func bodySections(body *hclwrite.Body) []bodySection {
+	type item struct {
+		name   string
+		block  *hclwrite.Block
+		tokens hclwrite.Tokens
+	}
+	items := make(map[*hclwrite.Token]item)
+	for name, attr := range body.Attributes() {
+		tokens := attr.BuildTokens(nil)
+		items[tokens[0]] = item{name: name, tokens: tokens}
+	}
+	for _, block := range body.Blocks() {
+		tokens := block.BuildTokens(nil)
+		items[tokens[0]] = item{block: block, tokens: tokens}
+	}
+
+	var sections []bodySection
+	var gap hclwrite.Tokens
+	tokens := body.BuildTokens(nil)
+	for i := 0; i < len(tokens); {
+		it, ok := items[tokens[i]]
+		if !ok {
+			gap = append(gap, tokens[i])
+			i++
+			continue
+		}
+		i += len(it.tokens)
+
+		// Items end with a newline, so any newline between two items ends a
+		// blank line
+		lead := leadingComments(it.tokens)
+		if len(sections) == 0 || len(gap) > 0 || len(lead) > 0 {
+			sections = append(sections, bodySection{Header: append(gap, copyTokens(lead)...)})
+			gap = nil
+		}
+		// The comments now belong to the header, so they are left out when a
+		// block is written with its lead comments
+		for _, token := range lead {
+			token.Bytes, token.SpacesBefore = nil, 0
+		}
+
+		section := &sections[len(sections)-1]
+		if it.block != nil {
+			section.Blocks = append(section.Blocks, it.block)
+		} else {
+			section.Attrs = append(section.Attrs, it.name)
+		}
+	}
+
+	if len(gap) > 0 {
+		sections = append(sections, bodySection{Header: gap})
+	}
+	return sections
+}
+
+// leadingComments returns the comment tokens at the start of an item's tokens
+func leadingComments(tokens hclwrite.Tokens) hclwrite.Tokens {
+	end := 0
+	for end < len(tokens) && tokens[end].Type == hclsyntax.TokenComment {
+		end++
+	}
+	return tokens[:end]
+}
+
+// copyTokens returns copies of tokens
+func copyTokens(tokens hclwrite.Tokens) hclwrite.Tokens {
+	copies := make(hclwrite.Tokens, len(tokens))
+	for i, token := range tokens {
+		copied := *token
+		copies[i] = &copied
+	}
+	return copies
+}
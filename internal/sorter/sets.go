@@ -0,0 +1,255 @@
+package sorter
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// listElement is a list element together with the comments that belong to it
+type listElement struct {
+	Key      string
+	Lead     hclwrite.Tokens // comment lines and blank lines before the value
+	Value    hclwrite.Tokens
+	Trailing hclwrite.Tokens // comment or newline ending the value's line
+}
+
+// isSetAttribute reports whether an attribute at the given block path is
+// configured to hold a set, either by name or by its dotted path
+func (s *Sorter) isSetAttribute(path []string, name string) bool {
+	if s.setAttributes[name] {
+		return true
+	}
+	return len(path) > 0 && s.setAttributes[strings.Join(path, ".")+"."+name]
+}
+
+// sortSetLists sorts the elements of set-like list literals in an expression:
+// the expression itself when it holds a set, and any list passed to toset.
+// Only lists whose elements are all literals or references are sorted. It
+// returns nil when nothing was sorted.
+func (s *Sorter) sortSetLists(tokens hclwrite.Tokens, ctx exprContext) hclwrite.Tokens {
+	src := tokens.Bytes()
+	expr, diags := hclsyntax.ParseExpression(src, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil
+	}
+
+	var tuples []*hclsyntax.TupleConsExpr
+	if tuple, ok := expr.(*hclsyntax.TupleConsExpr); ok && ctx.IsSet {
+		tuples = append(tuples, tuple)
+	}
+	_ = hclsyntax.VisitAll(expr, func(node hclsyntax.Node) hcl.Diagnostics {
+		call, ok := node.(*hclsyntax.FunctionCallExpr)
+		if !ok || call.Name != "toset" || len(call.Args) != 1 {
+			return nil
+		}
+		if tuple, ok := call.Args[0].(*hclsyntax.TupleConsExpr); ok {
+			tuples = append(tuples, tuple)
+		}
+		return nil
+	})
+	if len(tuples) == 0 {
+		return nil
+	}
+
+	nodes := buildTokenTree(tokens)
+	lists := make(map[*hclwrite.Token]*tokenNode)
+	indexTokenTree(nodes, lists)
+	starts := tokenStartOffsets(tokens)
+
+	sorted := false
+	for _, tuple := range tuples {
+		keys, ok := setElementKeys(tuple, src)
+		if !ok || len(keys) < 2 {
+			continue
+		}
+
+		openIdx, found := starts[tuple.SrcRange.Start.Byte]
+		if !found || tokens[openIdx].Type != hclsyntax.TokenOBrack {
+			continue
+		}
+		list, found := lists[tokens[openIdx]]
+		if !found {
+			continue
+		}
+
+		sortedList := s.sortListElements(appendTokens(nil, []*tokenNode{list}), keys, ctx.Name)
+		if sortedList == nil {
+			continue
+		}
+		list.setChildren(tokenNodes(sortedList[1 : len(sortedList)-1]))
+		sorted = true
+	}
+
+	if !sorted {
+		return nil
+	}
+	return appendTokens(make(hclwrite.Tokens, 0, len(tokens)+len(tuples)), nodes)
+}
+
+// setElementKeys returns the sort key of each element of a list whose
+// elements are all literal values or references
+func setElementKeys(tuple *hclsyntax.TupleConsExpr, src []byte) ([]string, bool) {
+	keys := make([]string, 0, len(tuple.Exprs))
+	for _, elem := range tuple.Exprs {
+		switch e := elem.(type) {
+		case *hclsyntax.TemplateExpr:
+			if !e.IsStringLiteral() {
+				return nil, false
+			}
+			value, diags := e.Value(nil)
+			if diags.HasErrors() {
+				return nil, false
+			}
+			keys = append(keys, value.AsString())
+		case *hclsyntax.LiteralValueExpr, *hclsyntax.ScopeTraversalExpr:
+			rng := elem.Range()
+			keys = append(keys, string(src[rng.Start.Byte:rng.End.Byte]))
+		default:
+			return nil, false
+		}
+	}
+	return keys, true
+}
+
+// sortListElements sorts the elements of a list literal, keeping comments with
+// the element they describe and reporting duplicate elements
+func (s *Sorter) sortListElements(tokens hclwrite.Tokens, keys []string, name string) hclwrite.Tokens {
+	header, elements, trailingComma, footer, ok := splitListElements(tokens[1 : len(tokens)-1])
+	if !ok || len(elements) != len(keys) {
+		return nil
+	}
+	for i := range elements {
+		elements[i].Key = keys[i]
+	}
+
+	sort.SliceStable(elements, func(i, j int) bool {
+		return s.less(elements[i].Key, elements[j].Key)
+	})
+
+	if name == "" {
+		name = "toset()"
+	}
+	for i := 1; i < len(elements); i++ {
+		if elements[i].Key == elements[i-1].Key && (i == 1 || elements[i-2].Key != elements[i].Key) {
+			s.diags = append(s.diags, &hcl.Diagnostic{
+				Severity: hcl.DiagWarning,
+				Summary:  "Duplicate set element",
+				Detail:   fmt.Sprintf("%s appears more than once in %s.", strings.TrimSpace(string(elements[i].Value.Bytes())), name),
+			})
+		}
+	}
+
+	result := make(hclwrite.Tokens, 0, len(tokens)+1)
+	result = append(result, tokens[0])
+	result = append(result, header...)
+	for i, element := range elements {
+		// A comment line leading an element starts on a line of its own, so it
+		// is not read as a comment on the element written before it
+		if len(element.Lead) > 0 && bytes.HasSuffix(element.Lead[0].Bytes, []byte("\n")) && !endsLine(result[len(result)-1]) {
+			result = append(result, &hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte("\n")})
+		}
+		result = append(result, element.Lead...)
+		result = append(result, element.Value...)
+		if i < len(elements)-1 || trailingComma {
+			result = append(result, &hclwrite.Token{
+				Type:  hclsyntax.TokenComma,
+				Bytes: []byte(","),
+			})
+		}
+		result = append(result, element.Trailing...)
+	}
+	result = append(result, footer...)
+	result = append(result, tokens[len(tokens)-1])
+
+	return result
+}
+
+// splitListElements splits the tokens between the brackets of a list literal
+// into elements. A newline directly after the opening bracket is returned as
+// the header and comments before the closing bracket as the footer. A comment
+// directly after the opening bracket leads the first element.
+func splitListElements(tokens hclwrite.Tokens) (header hclwrite.Tokens, elements []listElement, trailingComma bool, footer hclwrite.Tokens, ok bool) {
+	i := 0
+	if i < len(tokens) && tokens[i].Type == hclsyntax.TokenNewline {
+		header = tokens[:1]
+		i++
+	}
+
+	hasComma := false
+	for i < len(tokens) {
+		var element listElement
+		for i < len(tokens) && isLineEndToken(tokens[i]) {
+			element.Lead = append(element.Lead, tokens[i])
+			i++
+		}
+		if i >= len(tokens) {
+			footer = element.Lead
+			break
+		}
+
+		// Elements separated only by newlines are not valid list syntax
+		if len(elements) > 0 && !hasComma {
+			return nil, nil, false, nil, false
+		}
+
+		depth := 0
+		for i < len(tokens) {
+			token := tokens[i]
+			if depth == 0 && (token.Type == hclsyntax.TokenComma || isLineEndToken(token)) {
+				break
+			}
+			switch token.Type {
+			case hclsyntax.TokenOBrack, hclsyntax.TokenOBrace, hclsyntax.TokenOParen:
+				depth++
+			case hclsyntax.TokenCBrack, hclsyntax.TokenCBrace, hclsyntax.TokenCParen:
+				depth--
+			}
+			element.Value = append(element.Value, token)
+			i++
+		}
+
+		hasComma = i < len(tokens) && tokens[i].Type == hclsyntax.TokenComma
+		if hasComma {
+			i++
+		}
+		if i < len(tokens) && isLineEndToken(tokens[i]) {
+			element.Trailing = hclwrite.Tokens{tokens[i]}
+			i++
+		}
+
+		elements = append(elements, element)
+	}
+
+	return header, elements, hasComma, footer, len(elements) > 0
+}
+
+// isLineEndToken reports whether a token is a newline or a comment
+func isLineEndToken(token *hclwrite.Token) bool {
+	return token.Type == hclsyntax.TokenNewline || token.Type == hclsyntax.TokenComment
+}
+
+// endsLine reports whether a token ends its line: a newline, or a comment
+// that runs to the end of the line
+func endsLine(token *hclwrite.Token) bool {
+	return token.Type == hclsyntax.TokenNewline ||
+		token.Type == hclsyntax.TokenComment && bytes.HasSuffix(token.Bytes, []byte("\n"))
+}
+
+// tokenStartOffsets maps the byte offset at which each token starts in the
+// rendered tokens to the token's index
+func tokenStartOffsets(tokens hclwrite.Tokens) map[int]int {
+	starts := make(map[int]int, len(tokens))
+	offset := 0
+	for i, token := range tokens {
+		offset += token.SpacesBefore
+		starts[offset] = i
+		offset += len(token.Bytes)
+	}
+	return starts
+}
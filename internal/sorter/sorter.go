@@ -1,19 +1,48 @@
 package sorter
 
 import (
-	"sort"
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
 )
 
-type Sorter struct{}
+// Sorter sorts files according to a Config. It keeps the state of the file it
+// is sorting between calls, so a Sorter must not be used by several goroutines
+// at once; create one Sorter per goroutine instead.
+type Sorter struct {
+	config         Config
+	blockTypeOrder map[string]int
+	setAttributes  map[string]bool
+
+	// diags collects warnings raised while sorting a file
+	diags hcl.Diagnostics
+
+	// positions holds where each token of the file started before sorting. It
+	// is only computed when locals are ordered by dependency, whose cycle
+	// warnings refer to the source.
+	positions map[*hclwrite.Token]hcl.Pos
+
+	// rules holds the enabled rules by the scopes they arrange, in the order
+	// Rules lists them
+	rules map[scope][]Rule
+
+	// observer and placements record where elements were written
+	observer   Observer
+	placements []placement
+}
 
 type AttrInfo struct {
 	Name        string
 	Expr        *hclwrite.Expression
 	IsMultiLine bool
+	Pos         int       // position in the authored body
+	rng         hcl.Range // source range of the attribute, when known
 }
 
 type BlockInfo struct {
@@ -22,31 +51,97 @@ type BlockInfo struct {
 	Name  string
 }
 
+// exprContext describes where an expression being sorted appears
+type exprContext struct {
+	Name     string   // attribute name, used in diagnostics
+	Address  string   // attribute address, used in change records
+	KeyOrder []string // keys placed first in a top-level object
+	IsSet    bool     // whether the attribute holds a set
+}
+
+// bodyItem is an attribute or nested block in a body layout.
+type bodyItem struct {
+	Attr  *AttrInfo
+	Block *hclwrite.Block
+	// RuleID and Rule describe why the item is written in its position
+	RuleID string
+	Rule   string
+}
+
 func New() *Sorter {
-	return &Sorter{}
+	return NewWithConfig(DefaultConfig())
 }
 
-var blockTypeOrder = map[string]int{
-	"terraform": 0,
-	"provider":  1,
-	"variable":  2,
-	"locals":    3,
-	"data":      4,
-	"resource":  5,
-	"module":    6,
-	"output":    7,
+func NewWithConfig(config Config) *Sorter {
+	blockTypeOrder := make(map[string]int, len(config.BlockOrder))
+	for i, blockType := range config.BlockOrder {
+		blockTypeOrder[blockType] = i
+	}
+
+	setAttributes := make(map[string]bool, len(config.SetAttributes))
+	for _, name := range config.SetAttributes {
+		setAttributes[name] = true
+	}
+
+	return &Sorter{
+		config:         config,
+		blockTypeOrder: blockTypeOrder,
+		setAttributes:  setAttributes,
+		rules:          enabledRules(config.DisabledRules),
+	}
+}
+
+// Config returns the configuration the sorter applies.
+func (s *Sorter) Config() Config {
+	return s.config
+}
+
+// sequentialBlockTypes are nested blocks that take effect in the order they are
+// written. They keep their authored order and follow the other nested blocks.
+var sequentialBlockTypes = map[string]bool{
+	"postcondition": true,
+	"precondition":  true,
+	"provisioner":   true,
 }
 
 var metaArgumentOrder = map[string]int{
 	"count":            0,
 	"for_each":         1,
+	"provider":         2,
 	"depends_on":       998,
 	"force_new":        999,
 	"lifecycle":        1000,
 	"triggers_replace": 1001,
 }
 
-func (s *Sorter) SortFile(file *hclwrite.File) {
+// SortFile sorts the file in place and returns any warnings raised while
+// sorting, such as reference cycles between locals. Sorted bodies hold their
+// attributes as raw tokens, so the file should be rendered rather than
+// queried for attributes afterwards.
+func (s *Sorter) SortFile(file *hclwrite.File) hcl.Diagnostics {
+	s.diags = nil
+	if s.config.LocalsOrder == LocalsOrderDependency {
+		s.positions = tokenPositions(file.BuildTokens(nil))
+	}
+
+	var oldLines map[*hclwrite.Token]int
+	if s.observer != nil {
+		oldLines = tokenLines(file.BuildTokens(nil))
+	}
+
+	s.sortFile(file)
+
+	if s.observer != nil {
+		s.notifyObserver(oldLines, file)
+		s.placements = nil
+	}
+
+	diags := s.diags
+	s.diags, s.positions = nil, nil
+	return diags
+}
+
+func (s *Sorter) sortFile(file *hclwrite.File) {
 	body := file.Body()
 	blocks := body.Blocks()
 	attrs := body.Attributes()
@@ -75,266 +170,306 @@ func (s *Sorter) SortFile(file *hclwrite.File) {
 		}
 	}
 
-	sort.Slice(blockInfos, func(i, j int) bool {
-		return s.compareBlocks(blockInfos[i], blockInfos[j])
-	})
+	layout := &fileLayout{blocks: blockInfos}
+	for _, r := range s.rules[fileScope] {
+		r.arrangeFile(s, layout)
+	}
 
 	// Sort attributes if they exist
-	var sortedAttrs []AttrInfo
+	var attrInfos map[string]AttrInfo
 	if len(attrs) > 0 {
+		positions := attributePositions(body)
+		attrInfos = make(map[string]AttrInfo, len(attrs))
 		for name, attr := range attrs {
-			expr := attr.Expr()
-			sortedExpr := s.sortExpression(expr)
-			isMultiLine := s.isMultiLineAttribute(sortedExpr)
-
-			sortedAttrs = append(sortedAttrs, AttrInfo{
+			sortedExpr := s.sortExpression(attr.Expr(), exprContext{Name: name, Address: name})
+			attrInfos[name] = AttrInfo{
 				Name:        name,
 				Expr:        sortedExpr,
-				IsMultiLine: isMultiLine,
-			})
+				IsMultiLine: s.isMultiLineAttribute(sortedExpr),
+				Pos:         positions[name],
+			}
 		}
-
-		sort.Slice(sortedAttrs, func(i, j int) bool {
-			return sortedAttrs[i].Name < sortedAttrs[j].Name
-		})
 	}
 
 	// Clear and rebuild the entire body
 	body.Clear()
 
-	// Add sorted attributes first (if any)
-	if len(sortedAttrs) > 0 {
-		s.writeAttributeGroup(body, sortedAttrs)
-		// Add blank line between attributes and blocks
-		if len(blockInfos) > 0 {
+	// Add sorted attributes first (if any), then a blank line before the blocks
+	if len(attrInfos) > 0 {
+		s.writeBody(body, "", ArgumentOrder{}, attrInfos, nil, nil, "")
+		if layout.spacing {
 			body.AppendNewline()
 		}
 	}
 
 	// Add sorted blocks
-	for i, blockInfo := range blockInfos {
-		s.sortBlockAttributes(blockInfo.Block)
-
-		// Add blank line before certain block types for grouping
-		if i > 0 {
-			currentOrder, currentExists := blockTypeOrder[blockInfo.Type]
-			prevOrder, prevExists := blockTypeOrder[blockInfos[i-1].Type]
-
-			// Add blank line in specific cases only
-			if currentExists && prevExists {
-				// Add blank line when transitioning from early group (0-2) to later group (3+)
-				if prevOrder <= 2 && currentOrder >= 3 {
-					body.AppendNewline()
-				}
-				// Add blank line between all blocks in the later group (3+)
-				if prevOrder >= 3 && currentOrder >= 3 {
-					body.AppendNewline()
-				}
-				// NO blank lines between terraform/provider/variable (both <= 2) - do nothing
-			} else if !currentExists || !prevExists {
-				// Add blank line for unknown block types
-				body.AppendNewline()
-			}
+	for i, blockInfo := range layout.blocks {
+		address := blockAddress("", blockInfo.Block)
+		s.recordBlock(blockInfo.Block, address, RuleBlockOrder, layout.rule(blockInfo.Block))
+		s.sortBlockAttributes(blockInfo.Block, s.blockPath(nil, blockInfo.Block), bodyAddress(address, blockInfo.Block))
+
+		// Set each block apart from the one before it with a blank line
+		if i > 0 && layout.spacing {
+			body.AppendNewline()
 		}
 
 		body.AppendBlock(blockInfo.Block)
 
-		// Always add a newline after each block
-		if i < len(blockInfos)-1 {
+		// Always add a newline after each block, ending the block's line
+		// first when it was written last in the file without a newline
+		if i < len(layout.blocks)-1 {
+			if tokens := blockInfo.Block.BuildTokens(nil); tokens[len(tokens)-1].Type != hclsyntax.TokenNewline {
+				body.AppendNewline()
+			}
 			body.AppendNewline()
 		}
 	}
 }
 
 func (s *Sorter) compareBlocks(a, b BlockInfo) bool {
-	orderA, existsA := blockTypeOrder[a.Type]
-	orderB, existsB := blockTypeOrder[b.Type]
+	if a.Type != b.Type {
+		return s.blockTypeLess(a.Type, b.Type)
+	}
 
-	if existsA && existsB {
-		if orderA != orderB {
-			return orderA < orderB
+	// Import blocks are sorted by target address and removed blocks by source
+	// address. Moved blocks compare equal and keep their authored order.
+	if a.Type == "import" && b.Type == "import" {
+		toA := s.getAttributeSource(a.Block, "to")
+		toB := s.getAttributeSource(b.Block, "to")
+		if toA != toB {
+			return s.less(toA, toB)
+		}
+	}
+	if a.Type == "removed" && b.Type == "removed" {
+		fromA := s.getAttributeSource(a.Block, "from")
+		fromB := s.getAttributeSource(b.Block, "from")
+		if fromA != fromB {
+			return s.less(fromA, fromB)
 		}
-	} else if existsA != existsB {
+	}
+
+	return s.less(a.Name, b.Name)
+}
+
+// blockTypeLess reports whether blocks of type a are written before blocks of
+// type b: listed types in block order, then unlisted types alphabetically
+func (s *Sorter) blockTypeLess(a, b string) bool {
+	orderA, existsA := s.blockTypeOrder[a]
+	orderB, existsB := s.blockTypeOrder[b]
+
+	switch {
+	case existsA && existsB:
+		return orderA < orderB
+	case existsA != existsB:
 		return existsA
-	} else if !existsA && !existsB {
-		if a.Type != b.Type {
-			return a.Type < b.Type
+	default:
+		return a < b
+	}
+}
+
+// orderMovedBlocks reorders the moved blocks in sorted top-level blocks so that
+// a block moving an address to X precedes the block moving X onwards. Blocks
+// that are not part of a chain keep their authored order.
+func (s *Sorter) orderMovedBlocks(blockInfos []BlockInfo) {
+	var indexes []int
+	var moved []BlockInfo
+	for i, blockInfo := range blockInfos {
+		if blockInfo.Type == "moved" {
+			indexes = append(indexes, i)
+			moved = append(moved, blockInfo)
 		}
 	}
+	if len(moved) < 2 {
+		return
+	}
+
+	from := make([]string, len(moved))
+	to := make([]string, len(moved))
+	for i, blockInfo := range moved {
+		from[i] = s.getAttributeSource(blockInfo.Block, "from")
+		to[i] = s.getAttributeSource(blockInfo.Block, "to")
+	}
 
-	// Special handling for validation blocks - sort by error_message
-	if a.Type == "validation" && b.Type == "validation" {
-		errorMsgA := s.getValidationErrorMessage(a.Block)
-		errorMsgB := s.getValidationErrorMessage(b.Block)
-		if errorMsgA != errorMsgB {
-			return errorMsgA < errorMsgB
+	// Count the chain predecessors of each block
+	predecessors := make([]int, len(moved))
+	for i := range moved {
+		for j := range moved {
+			if i != j && to[j] != "" && to[j] == from[i] {
+				predecessors[i]++
+			}
 		}
 	}
 
-	// Special handling for dynamic blocks - sort by block label first, then by content id/label/name, then by for_each expression
-	if a.Type == "dynamic" && b.Type == "dynamic" {
-		// Dynamic blocks have labels (the resource type they're generating) - case insensitive
-		labelA := strings.ToLower(s.getDynamicBlockLabel(a.Block))
-		labelB := strings.ToLower(s.getDynamicBlockLabel(b.Block))
-		if labelA != labelB {
-			return labelA < labelB
+	// Repeatedly emit the first authored block without pending predecessors,
+	// falling back to authored order if the chain contains a cycle
+	emitted := make([]bool, len(moved))
+	ordered := make([]BlockInfo, 0, len(moved))
+	for len(ordered) < len(moved) {
+		next := -1
+		for i := range moved {
+			if !emitted[i] && predecessors[i] == 0 {
+				next = i
+				break
+			}
 		}
-		// If same label, sort by content id/label/name attributes - case insensitive
-		contentKeyA := strings.ToLower(s.getDynamicContentSortKey(a.Block))
-		contentKeyB := strings.ToLower(s.getDynamicContentSortKey(b.Block))
-		if contentKeyA != contentKeyB {
-			return contentKeyA < contentKeyB
+		if next == -1 {
+			for i := range moved {
+				if !emitted[i] {
+					next = i
+					break
+				}
+			}
 		}
-		// If same content key, sort by for_each expression content
-		forEachA := s.getDynamicForEachContent(a.Block)
-		forEachB := s.getDynamicForEachContent(b.Block)
-		if forEachA != forEachB {
-			return forEachA < forEachB
+
+		emitted[next] = true
+		ordered = append(ordered, moved[next])
+		for i := range moved {
+			if !emitted[i] && to[next] != "" && to[next] == from[i] {
+				predecessors[i]--
+			}
 		}
 	}
 
-	return a.Name < b.Name
+	for i, index := range indexes {
+		blockInfos[index] = ordered[i]
+	}
 }
 
-func (s *Sorter) sortBlockAttributes(block *hclwrite.Block) {
+// blockPath returns the path used to match schema and set attribute entries
+// for a block nested at parent. Resources, data sources and providers include
+// their type label, dynamic blocks are named by their label and content blocks
+// take the path of their dynamic block.
+func (s *Sorter) blockPath(parent []string, block *hclwrite.Block) []string {
+	labels := block.Labels()
+	path := append([]string(nil), parent...)
+
+	switch block.Type() {
+	case "resource", "data", "ephemeral", "provider":
+		path = append(path, block.Type())
+		if len(labels) > 0 {
+			path = append(path, labels[0])
+		}
+	case "dynamic":
+		if len(labels) > 0 {
+			path = append(path, labels[0])
+		}
+	case "content":
+	default:
+		path = append(path, block.Type())
+	}
+
+	return path
+}
+
+// sortBlockAttributes sorts the body of a block whose path is given by path
+func (s *Sorter) sortBlockAttributes(block *hclwrite.Block, path []string, address string) {
 	body := block.Body()
 	attrs := body.Attributes()
 	nestedBlocks := body.Blocks()
 
-	if len(attrs) == 0 && len(nestedBlocks) == 0 {
+	if len(attrs) == 0 && len(nestedBlocks) == 0 || s.bodiesUnchanged() {
 		return
 	}
 
-	// Categorize attributes
-	var earlyAttrs []AttrInfo
-	var singleLineAttrs []AttrInfo
-	var multiLineAttrs []AttrInfo
-	var lateAttrs []AttrInfo
+	order := s.config.ArgumentOrder[block.Type()]
 
+	positions := attributePositions(body)
+	attrInfos := make(map[string]AttrInfo, len(attrs))
 	for name, attr := range attrs {
 		expr := attr.Expr()
-		sortedExpr := s.sortExpression(expr)
+		sortedExpr := s.sortExpression(expr, exprContext{
+			Name:     name,
+			Address:  joinAddress(address, name),
+			KeyOrder: order.ObjectKeys,
+			IsSet:    s.isSetAttribute(path, name),
+		})
 
-		isMultiLine := s.isMultiLineAttribute(sortedExpr)
-		attrInfo := AttrInfo{
+		attrInfos[name] = AttrInfo{
 			Name:        name,
 			Expr:        sortedExpr,
-			IsMultiLine: isMultiLine,
-		}
-
-		if s.isEarlyAttribute(name) {
-			earlyAttrs = append(earlyAttrs, attrInfo)
-		} else if s.isLateAttribute(name) {
-			lateAttrs = append(lateAttrs, attrInfo)
-		} else if isMultiLine {
-			multiLineAttrs = append(multiLineAttrs, attrInfo)
-		} else {
-			singleLineAttrs = append(singleLineAttrs, attrInfo)
-		}
-	}
-
-	// Categorize blocks
-	var regularBlocks []*hclwrite.Block
-	var lifecycleBlocks []*hclwrite.Block
-
-	for _, nestedBlock := range nestedBlocks {
-		if nestedBlock.Type() == "lifecycle" {
-			lifecycleBlocks = append(lifecycleBlocks, nestedBlock)
-		} else {
-			regularBlocks = append(regularBlocks, nestedBlock)
+			IsMultiLine: s.isMultiLineAttribute(sortedExpr),
+			Pos:         positions[name],
+			rng:         s.attributeRange(attr),
 		}
 	}
 
-	// Sort all categories
-	sort.Slice(earlyAttrs, func(i, j int) bool {
-		return s.compareEarlyAttributes(earlyAttrs[i].Name, earlyAttrs[j].Name)
-	})
-	sort.Slice(singleLineAttrs, func(i, j int) bool {
-		return singleLineAttrs[i].Name < singleLineAttrs[j].Name
-	})
-	sort.Slice(multiLineAttrs, func(i, j int) bool {
-		return multiLineAttrs[i].Name < multiLineAttrs[j].Name
-	})
-	sort.Slice(lateAttrs, func(i, j int) bool {
-		return s.compareLateAttributes(lateAttrs[i].Name, lateAttrs[j].Name)
-	})
-	sort.Slice(regularBlocks, func(i, j int) bool {
-		return s.compareBlocks(BlockInfo{Block: regularBlocks[i], Type: regularBlocks[i].Type()},
-			BlockInfo{Block: regularBlocks[j], Type: regularBlocks[j].Type()})
-	})
-
-	// Now rebuild the body in the correct order
-	// First remove all existing attributes and blocks
-	for name := range attrs {
-		body.RemoveAttribute(name)
-	}
-	for _, block := range nestedBlocks {
-		body.RemoveBlock(block)
-	}
-
-	// Add content in the correct order
-	// 1. Early meta-arguments (count, for_each)
-	s.writeAttributeGroup(body, earlyAttrs)
-
-	// Add blank line after early meta-arguments if we have them and other content
-	hasOtherContent := len(singleLineAttrs) > 0 || len(multiLineAttrs) > 0 || len(lateAttrs) > 0 ||
-		len(regularBlocks) > 0 || len(lifecycleBlocks) > 0
-	if len(earlyAttrs) > 0 && hasOtherContent {
-		body.AppendNewline()
+	if !s.config.Sections {
+		// First remove all existing attributes and blocks
+		trailing := clearItems(body, true)
+		s.writeBody(body, block.Type(), order, attrInfos, nestedBlocks, path, address)
+		body.AppendUnstructuredTokens(trailing)
+		return
 	}
 
-	// 2. Single-line regular attributes
-	s.writeAttributeGroup(body, singleLineAttrs)
-
-	// 3. Regular nested blocks (not lifecycle) - recursively sort them
-	for i, block := range regularBlocks {
-		// Add blank line before blocks if we have attributes or previous blocks
-		if len(singleLineAttrs) > 0 || i > 0 {
-			body.AppendNewline()
+	// Each section is sorted on its own after its header
+	sections := bodySections(body)
+	body.Clear()
+	for _, section := range sections {
+		body.AppendUnstructuredTokens(section.Header)
+		if len(section.Attrs) > 0 || len(section.Blocks) > 0 {
+			s.writeBody(body, block.Type(), order, section.attrInfos(attrInfos), section.Blocks, path, address)
 		}
-		s.sortBlockAttributes(block)
-		body.AppendBlock(block)
 	}
+}
 
-	// 4. Multi-line regular attributes
-	if len(multiLineAttrs) > 0 {
-		// Add blank line before multi-line attributes if we have regular content
-		if len(singleLineAttrs) > 0 || len(regularBlocks) > 0 {
-			body.AppendNewline()
-		}
-		s.writeAttributeGroup(body, multiLineAttrs)
+// writeBody lays out the attributes and nested blocks of a body of the given
+// block type, lets the enabled rules arrange them and writes them to body
+func (s *Sorter) writeBody(body *hclwrite.Body, blockType string, order ArgumentOrder, attrInfos map[string]AttrInfo, nestedBlocks []*hclwrite.Block, path []string, address string) {
+	layout := newBodyLayout(blockType, order, attrInfos, nestedBlocks)
+	for _, r := range s.rules[bodyScope] {
+		r.arrangeBody(s, layout)
 	}
 
-	// 5. Late meta-arguments (depends_on attributes)
-	if len(lateAttrs) > 0 {
-		// Add blank line before late attributes if we have regular content
-		if len(singleLineAttrs) > 0 || len(regularBlocks) > 0 || len(multiLineAttrs) > 0 {
-			body.AppendNewline()
-		}
-		s.writeAttributeGroup(body, lateAttrs)
-	}
+	var prev bodyItem
+	prevGroup := bodyGroup(-1)
+	for group := groupFirst; group < groupCount; group++ {
+		for _, item := range layout.items(group) {
+			if prevGroup >= 0 && layout.spacing && layout.separated(prev, prevGroup, item, group) {
+				body.AppendNewline()
+			}
 
-	// 6. Late blocks (lifecycle) - recursively sort them
-	for _, block := range lifecycleBlocks {
-		// Add blank line before lifecycle blocks
-		if len(singleLineAttrs) > 0 || len(regularBlocks) > 0 || len(multiLineAttrs) > 0 || len(lateAttrs) > 0 {
-			body.AppendNewline()
+			if item.Block != nil {
+				blockAddress := blockAddress(address, item.Block)
+				s.recordBlock(item.Block, blockAddress, item.RuleID, item.Rule)
+				s.sortBlockAttributes(item.Block, s.blockPath(path, item.Block), blockAddress)
+				body.AppendBlock(item.Block)
+			} else {
+				s.recordAttribute(*item.Attr, address, item.RuleID, item.Rule)
+				appendAttribute(body, item.Attr.Name, item.Attr.Expr)
+			}
+			prev, prevGroup = item, group
 		}
-		s.sortBlockAttributes(block)
-		body.AppendBlock(block)
 	}
 }
 
-func (s *Sorter) isEarlyAttribute(name string) bool {
+// isEarlyAttribute reports whether name is a meta-argument written first
+func isEarlyAttribute(name string) bool {
 	order, exists := metaArgumentOrder[name]
 	return exists && order < 500
 }
 
-func (s *Sorter) isLateAttribute(name string) bool {
+// isLateAttribute reports whether name is a meta-argument written last
+func isLateAttribute(name string) bool {
 	order, exists := metaArgumentOrder[name]
 	return exists && order >= 998
 }
 
+// attributePositions maps each attribute in a body to its position in the
+// authored body
+func attributePositions(body *hclwrite.Body) map[string]int {
+	tokenIndex := make(map[*hclwrite.Token]int)
+	for i, token := range body.BuildTokens(nil) {
+		tokenIndex[token] = i
+	}
+
+	attrs := body.Attributes()
+	positions := make(map[string]int, len(attrs))
+	for name, attr := range attrs {
+		positions[name] = tokenIndex[attr.BuildTokens(nil)[0]]
+	}
+	return positions
+}
+
 func (s *Sorter) compareEarlyAttributes(a, b string) bool {
 	orderA, existsA := metaArgumentOrder[a]
 	orderB, existsB := metaArgumentOrder[b]
@@ -342,7 +477,7 @@ func (s *Sorter) compareEarlyAttributes(a, b string) bool {
 	if existsA && existsB {
 		return orderA < orderB
 	}
-	return a < b
+	return s.less(a, b)
 }
 
 func (s *Sorter) compareLateAttributes(a, b string) bool {
@@ -352,7 +487,7 @@ func (s *Sorter) compareLateAttributes(a, b string) bool {
 	if existsA && existsB {
 		return orderA < orderB
 	}
-	return a < b
+	return s.less(a, b)
 }
 
 func (s *Sorter) sortBodyAttributes(body *hclwrite.Body) {
@@ -362,144 +497,247 @@ func (s *Sorter) sortBodyAttributes(body *hclwrite.Body) {
 	}
 
 	// Build list of attributes with metadata
-	var attrInfos []AttrInfo
+	positions := attributePositions(body)
+	attrInfos := make(map[string]AttrInfo, len(attrs))
 	for name, attr := range attrs {
 		expr := attr.Expr()
 
 		// Sort the expression content if it's an object or similar
-		sortedExpr := s.sortExpression(expr)
-
-		isMultiLine := s.isMultiLineAttribute(sortedExpr)
+		sortedExpr := s.sortExpression(expr, exprContext{Name: name, Address: name})
 
-		attrInfos = append(attrInfos, AttrInfo{
+		attrInfos[name] = AttrInfo{
 			Name:        name,
 			Expr:        sortedExpr,
-			IsMultiLine: isMultiLine,
-		})
+			IsMultiLine: s.isMultiLineAttribute(sortedExpr),
+			Pos:         positions[name],
+		}
 	}
 
-	// Sort attributes alphabetically
-	sort.Slice(attrInfos, func(i, j int) bool {
-		return attrInfos[i].Name < attrInfos[j].Name
-	})
+	if !s.config.Sections {
+		// Remove existing attributes first
+		trailing := clearItems(body, false)
+		s.writeBody(body, "", ArgumentOrder{}, attrInfos, nil, nil, "")
+		body.AppendUnstructuredTokens(trailing)
+		return
+	}
 
-	// Remove existing attributes first
-	for name := range attrs {
-		body.RemoveAttribute(name)
+	sections := bodySections(body)
+	body.Clear()
+	for _, section := range sections {
+		body.AppendUnstructuredTokens(section.Header)
+		s.writeBody(body, "", ArgumentOrder{}, section.attrInfos(attrInfos), nil, nil, "")
 	}
+}
 
-	// Add attributes back in sorted order with proper spacing
-	s.writeAttributeGroup(body, attrInfos)
+// bodiesUnchanged reports whether every rule and option that rewrites block
+// bodies is off, so that bodies can be left exactly as written
+func (s *Sorter) bodiesUnchanged() bool {
+	return !s.config.SortSets && !s.config.SortJSONHeredocs &&
+		len(s.rules[bodyScope]) == 0 && len(s.rules[objectScope]) == 0
 }
 
-func (s *Sorter) isMultiLineAttribute(expr *hclwrite.Expression) bool {
-	tokens := expr.BuildTokens(nil)
-	for _, token := range tokens {
-		if token.Type == hclsyntax.TokenNewline {
-			return true
+// clearItems removes the attributes and nested blocks from a body, keeping the
+// comments and blank lines left between them, and returns the comments and
+// blank lines after the last item for the caller to write after the sorted
+// items. Unlike RemoveAttribute and RemoveBlock, which search the body for
+// each item, it runs in linear time.
+//
+// In the body of a block, a comment on the line of the opening brace is read
+// as a lead comment of the first item and holds the newline ending that line,
+// so it is kept in place. A block carries its lead comments along, so the
+// comment is emptied in the block.
+func clearItems(body *hclwrite.Body, inBlock bool) hclwrite.Tokens {
+	owned := make(map[*hclwrite.Token]bool)
+	for _, attr := range body.Attributes() {
+		for _, token := range attr.BuildTokens(nil) {
+			owned[token] = true
 		}
 	}
-	return false
-}
-
-func (s *Sorter) writeAttributeGroup(body *hclwrite.Body, attrs []AttrInfo) {
-	if len(attrs) == 0 {
-		return
+	blockOwned := make(map[*hclwrite.Token]bool)
+	for _, block := range body.Blocks() {
+		for _, token := range block.BuildTokens(nil) {
+			owned[token] = true
+			blockOwned[token] = true
+		}
 	}
 
-	// Separate single-line and multi-line attributes
-	var singleLineAttrs []AttrInfo
-	var multiLineAttrs []AttrInfo
+	var leftover hclwrite.Tokens
+	tokens := body.BuildTokens(nil)
+	end := len(tokens)
+	for end > 0 && !owned[tokens[end-1]] {
+		end--
+	}
+	trailing := tokens[end:]
 
-	for _, attr := range attrs {
-		if attr.IsMultiLine {
-			multiLineAttrs = append(multiLineAttrs, attr)
+	if inBlock && end > 0 && owned[tokens[0]] && tokens[0].Type == hclsyntax.TokenComment {
+		if blockOwned[tokens[0]] {
+			leftover = append(leftover, &hclwrite.Token{Type: hclsyntax.TokenComment, Bytes: tokens[0].Bytes})
+			tokens[0].Bytes = nil
 		} else {
-			singleLineAttrs = append(singleLineAttrs, attr)
+			leftover = append(leftover, tokens[0])
+		}
+	}
+	kept := len(leftover)
+	for _, token := range tokens[:end] {
+		// Blank lines directly after the opening brace are dropped by
+		// formatting, but not once they follow its comment
+		if owned[token] || kept > 0 && len(leftover) == kept && token.Type == hclsyntax.TokenNewline {
+			continue
 		}
+		leftover = append(leftover, token)
+	}
+	// A comment left last would be read as a lead comment of the first item
+	// written after it
+	if len(leftover) > kept && leftover[len(leftover)-1].Type == hclsyntax.TokenComment {
+		leftover = append(leftover, &hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte{'\n'}})
 	}
 
-	// Write single-line attributes first (grouped together)
-	for _, attrInfo := range singleLineAttrs {
-		body.SetAttributeRaw(attrInfo.Name, attrInfo.Expr.BuildTokens(nil))
+	body.Clear()
+	if len(leftover) > 0 {
+		body.AppendUnstructuredTokens(leftover)
 	}
+	return trailing
+}
 
-	// Write multi-line attributes with blank lines before each one
-	for i, attrInfo := range multiLineAttrs {
-		// Add blank line before multi-line attributes (except the first if no single-line attrs)
-		if len(singleLineAttrs) > 0 || i > 0 {
-			body.AppendNewline()
+// appendAttribute appends an attribute to a body as raw tokens. Unlike
+// SetAttributeRaw, which searches the body for an attribute of the same name,
+// it runs in constant time, but the attribute is not listed by Attributes.
+func appendAttribute(body *hclwrite.Body, name string, expr *hclwrite.Expression) {
+	tokens := hclwrite.Tokens{
+		{Type: hclsyntax.TokenIdent, Bytes: []byte(name)},
+		{Type: hclsyntax.TokenEqual, Bytes: []byte{'='}},
+	}
+	tokens = expr.BuildTokens(tokens)
+	tokens = append(tokens, &hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte{'\n'}})
+	body.AppendUnstructuredTokens(tokens)
+}
+
+func (s *Sorter) isMultiLineAttribute(expr *hclwrite.Expression) bool {
+	tokens := expr.BuildTokens(nil)
+	for _, token := range tokens {
+		if token.Type == hclsyntax.TokenNewline {
+			return true
 		}
-		body.SetAttributeRaw(attrInfo.Name, attrInfo.Expr.BuildTokens(nil))
 	}
+	return false
 }
 
-// sortExpression attempts to sort object expressions using token-based approach
-func (s *Sorter) sortExpression(expr *hclwrite.Expression) *hclwrite.Expression {
+// sortExpression sorts the keys of the object constructors in an expression.
+// Keys listed in the context's key order are placed first in a top-level object.
+func (s *Sorter) sortExpression(expr *hclwrite.Expression, ctx exprContext) *hclwrite.Expression {
 	tokens := expr.BuildTokens(nil)
 
-	// Parse the expression using HCL's AST parser to understand its structure
-	if s.isSimpleObjectExpression(tokens) {
-		// Only sort if it's a simple object with literal values
-		sortedTokens := s.sortObjectLiteral(tokens)
-		if sortedTokens != nil {
-			return s.tokensToExpression(sortedTokens)
+	if s.config.SortJSONHeredocs {
+		if sortedTokens := s.sortJSONHeredocs(tokens); sortedTokens != nil {
+			tokens = sortedTokens
+			expr = hclwrite.NewExpressionRaw(sortedTokens)
 		}
 	}
 
-	// Also check if this is an array that might contain objects
-	if len(tokens) > 0 && tokens[0].Type == hclsyntax.TokenOBrack {
-		// This is an array, process its contents
-		sortedTokens := s.sortArrayContents(tokens)
-		if sortedTokens != nil {
-			return s.tokensToExpression(sortedTokens)
+	// Sort the elements of set-like lists before sorting any objects
+	if s.config.SortSets {
+		if sortedTokens := s.sortSetLists(tokens, ctx); sortedTokens != nil {
+			tokens = sortedTokens
+			expr = hclwrite.NewExpressionRaw(sortedTokens)
 		}
 	}
 
-	return expr
-}
-
-// isSimpleObjectExpression uses HCL AST parsing to determine if an expression is a simple object
-func (s *Sorter) isSimpleObjectExpression(tokens hclwrite.Tokens) bool {
-	if len(tokens) < 3 {
-		return false
+	if len(s.rules[objectScope]) == 0 {
+		return expr
 	}
 
-	// Convert tokens back to source code
-	var src strings.Builder
-	for _, token := range tokens {
-		src.Write(token.Bytes)
+	objects := s.findSortableObjects(tokens, ctx.KeyOrder, ctx.Address)
+	if len(objects) == 0 {
+		return expr
 	}
-	sourceText := src.String()
 
-	// Check for complex expressions that should never be sorted
-	if strings.Contains(sourceText, "for ") ||
-		strings.Contains(sourceText, "${") ||
-		strings.Contains(sourceText, "=>") {
-		return false
-	}
+	nodes := buildTokenTree(tokens)
+	s.sortTokenTree(nodes, objects)
+	return hclwrite.NewExpressionRaw(appendTokens(make(hclwrite.Tokens, 0, len(tokens)), nodes))
+}
 
-	// Check if it's an object() type definition - these should be sorted
-	if strings.HasPrefix(strings.TrimSpace(sourceText), "object(") {
-		return true
+// sortableObject describes an object constructor whose keys may be sorted
+type sortableObject struct {
+	KeyOrder []string // keys placed first
+	Items    int      // number of items in the parsed object
+	Address  string   // address of the object, used in change records
+}
+
+// sortableFunctions lists the functions whose arguments are searched for
+// object constructors to sort
+var sortableFunctions = map[string]bool{
+	"jsonencode": true,
+	"list":       true,
+	"map":        true,
+	"merge":      true,
+	"object":     true,
+	"optional":   true,
+	"set":        true,
+	"tomap":      true,
+	"tuple":      true,
+	"yamlencode": true,
+}
+
+// findSortableObjects parses an expression and returns the opening brace of
+// each object constructor whose keys may be sorted. Objects are found inside
+// other objects, tuples, conditionals and the arguments of sortableFunctions;
+// for expressions, splats and templates are never entered. keyOrder applies
+// to the expression itself when it is an object, and the configured encoded
+// key order to objects passed to jsonencode and yamlencode.
+func (s *Sorter) findSortableObjects(tokens hclwrite.Tokens, keyOrder []string, address string) map[*hclwrite.Token]sortableObject {
+	src := tokens.Bytes()
+	expr, diags := hclsyntax.ParseExpression(src, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil
 	}
 
-	// Check if it's inside a function call like merge() - these should not be sorted
-	// This is a simple heuristic - if we see function names, don't sort
-	if strings.Contains(sourceText, "merge(") ||
-		strings.Contains(sourceText, "concat(") ||
-		strings.Contains(sourceText, "flatten(") {
-		return false
+	starts := tokenStartOffsets(tokens)
+	objects := make(map[*hclwrite.Token]sortableObject)
+
+	// nestedKeyOrder applies to objects nested within the expression
+	var visit func(expr hclsyntax.Expression, keyOrder, nestedKeyOrder []string, address string)
+	visit = func(expr hclsyntax.Expression, keyOrder, nestedKeyOrder []string, address string) {
+		switch e := expr.(type) {
+		case *hclsyntax.ObjectConsExpr:
+			sortable := true
+			for _, item := range e.Items {
+				if !s.isSimpleExpression(item.KeyExpr) {
+					sortable = false
+				}
+				keyRange := item.KeyExpr.Range()
+				key := strings.Trim(string(src[keyRange.Start.Byte:keyRange.End.Byte]), `"`)
+				visit(item.ValueExpr, nestedKeyOrder, nestedKeyOrder, joinAddress(address, key))
+			}
+			if idx, found := starts[e.SrcRange.Start.Byte]; sortable && found && tokens[idx].Type == hclsyntax.TokenOBrace {
+				objects[tokens[idx]] = sortableObject{KeyOrder: keyOrder, Items: len(e.Items), Address: address}
+			}
+		case *hclsyntax.TupleConsExpr:
+			for i, elem := range e.Exprs {
+				visit(elem, nestedKeyOrder, nestedKeyOrder, fmt.Sprintf("%s[%d]", address, i))
+			}
+		case *hclsyntax.ConditionalExpr:
+			visit(e.TrueResult, nestedKeyOrder, nestedKeyOrder, address)
+			visit(e.FalseResult, nestedKeyOrder, nestedKeyOrder, address)
+		case *hclsyntax.ParenthesesExpr:
+			visit(e.Expression, keyOrder, nestedKeyOrder, address)
+		case *hclsyntax.FunctionCallExpr:
+			if !sortableFunctions[e.Name] {
+				return
+			}
+			if e.Name == "jsonencode" || e.Name == "yamlencode" {
+				nestedKeyOrder = s.config.EncodedKeyOrder
+			}
+			for _, arg := range e.Args {
+				visit(arg, nestedKeyOrder, nestedKeyOrder, address)
+			}
+		}
 	}
+	visit(expr, keyOrder, nil, address)
 
-	// Only allow simple object literals like { key = value, ... }
-	return strings.HasPrefix(strings.TrimSpace(sourceText), "{") &&
-		strings.HasSuffix(strings.TrimSpace(sourceText), "}")
+	return objects
 }
 
 // isSimpleExpression recursively checks if an HCL expression contains only simple, literal values
-//
-//nolint:unused // Used recursively but linter doesn't detect it
 func (s *Sorter) isSimpleExpression(expr hclsyntax.Expression) bool {
 	switch e := expr.(type) {
 	case *hclsyntax.ObjectConsExpr:
@@ -534,8 +772,18 @@ func (s *Sorter) isSimpleExpression(expr hclsyntax.Expression) bool {
 		return true
 
 	case *hclsyntax.ObjectConsKeyExpr:
-		// Object keys (identifiers or literals used as keys)
-		return true
+		// Object keys are simple when they are a bare name or a literal, not
+		// a parenthesized or interpolated expression evaluated for its value
+		if e.ForceNonLiteral {
+			return false
+		}
+		if hcl.ExprAsKeyword(e.Wrapped) != "" {
+			return true
+		}
+		if _, isReference := e.Wrapped.(*hclsyntax.ScopeTraversalExpr); isReference {
+			return false
+		}
+		return s.isSimpleExpression(e.Wrapped)
 
 	case *hclsyntax.TemplateExpr:
 		// Template expressions like quoted strings
@@ -570,496 +818,341 @@ func (s *Sorter) isSimpleExpression(expr hclsyntax.Expression) bool {
 	}
 }
 
-// tokensToExpression converts tokens back to an expression by creating a temporary attribute
-func (s *Sorter) tokensToExpression(tokens hclwrite.Tokens) *hclwrite.Expression {
-	// Create a temporary body and set an attribute with the tokens
-	body := hclwrite.NewEmptyFile().Body()
-	body.SetAttributeRaw("temp", tokens)
-
-	// Extract the expression from the temporary attribute
-	attrs := body.Attributes()
-	if tempAttr, exists := attrs["temp"]; exists {
-		return tempAttr.Expr()
+// sortTokenTree sorts the sortable objects in a token tree, sorting the
+// objects nested in an entry before the entry is placed
+func (s *Sorter) sortTokenTree(nodes []*tokenNode, objects map[*hclwrite.Token]sortableObject) {
+	for _, node := range nodes {
+		if node.Close == nil {
+			continue
+		}
+		s.sortTokenTree(node.Children, objects)
+		node.newlines = countNewlines(node.Children)
+		if object, sortable := objects[node.Token]; sortable {
+			s.sortObjectLiteral(node, object)
+		}
 	}
-
-	return nil
 }
 
-// sortObjectLiteral sorts the keys in an object literal
-func (s *Sorter) sortObjectLiteral(tokens hclwrite.Tokens) hclwrite.Tokens {
-	// Find the opening brace
-	openBraceIdx := -1
-	for i, token := range tokens {
-		if token.Type == hclsyntax.TokenOBrace {
-			openBraceIdx = i
-			break
-		}
+// sortObjectLiteral lets the enabled rules arrange the entries of an object
+// literal, rebuilding the object when they were sorted. Objects whose entries
+// cannot all be parsed from the tokens are left unsorted.
+func (s *Sorter) sortObjectLiteral(node *tokenNode, object sortableObject) {
+	entries := s.parseObjectEntries(node.Children)
+	if len(entries) == 0 || len(entries) != object.Items {
+		return
 	}
 
-	if openBraceIdx == -1 {
-		return nil
+	layout := &objectLayout{object: object, entries: entries}
+	for _, r := range s.rules[objectScope] {
+		r.arrangeObject(s, layout)
 	}
-
-	// Parse the object entries
-	entries := s.parseObjectEntries(tokens, openBraceIdx)
-	if len(entries) == 0 {
-		return nil
+	if layout.sorted {
+		node.setChildren(s.rebuildObjectNodes(node, layout))
 	}
+}
 
-	// Separate single-line and multi-line entries
-	var singleLineEntries []ObjectEntry
-	var multiLineEntries []ObjectEntry
-
-	for _, entry := range entries {
-		if s.isMultiLineObjectEntry(entry) {
-			multiLineEntries = append(multiLineEntries, entry)
-		} else {
-			singleLineEntries = append(singleLineEntries, entry)
+// compareKeys orders keys listed in keyOrder first, in list order, followed by
+// the remaining keys in collation order
+func (s *Sorter) compareKeys(a, b string, keyOrder []string) bool {
+	rankA, rankB := len(keyOrder), len(keyOrder)
+	for i, key := range keyOrder {
+		if key == a {
+			rankA = i
+		}
+		if key == b {
+			rankB = i
 		}
 	}
 
-	// Sort both groups alphabetically by key
-	sort.Slice(singleLineEntries, func(i, j int) bool {
-		return singleLineEntries[i].Key < singleLineEntries[j].Key
-	})
-	sort.Slice(multiLineEntries, func(i, j int) bool {
-		return multiLineEntries[i].Key < multiLineEntries[j].Key
-	})
-
-	// Combine: single-line first, then multi-line
-	sortedEntries := append(singleLineEntries, multiLineEntries...)
-
-	// Rebuild the tokens
-	return s.rebuildObjectTokens(tokens, sortedEntries, openBraceIdx, len(singleLineEntries) > 0 && len(multiLineEntries) > 0)
+	if rankA != rankB {
+		return rankA < rankB
+	}
+	return s.less(a, b)
 }
 
+// ObjectEntry is a key-value pair of an object literal
 type ObjectEntry struct {
-	Key      string
-	Tokens   hclwrite.Tokens
-	StartIdx int
-	EndIdx   int
+	Key       string
+	KeyToken  *hclwrite.Token
+	Nodes     []*tokenNode // comments before the key, the key and the value
+	Comma     bool         // whether the value was followed by a comma
+	MultiLine bool         // whether the entry spans multiple lines
+	EndIdx    int          // index in the object after the entry
 }
 
-// parseObjectEntries parses key-value pairs from object tokens
-func (s *Sorter) parseObjectEntries(tokens hclwrite.Tokens, startIdx int) []ObjectEntry {
+// parseObjectEntries parses key-value pairs from the children of an object
+func (s *Sorter) parseObjectEntries(children []*tokenNode) []ObjectEntry {
 	var entries []ObjectEntry
 
-	i := startIdx + 1 // Skip opening brace
-	braceLevel := 1
-
-	for i < len(tokens) && braceLevel > 0 {
-		// Skip whitespace and comments
-		for i < len(tokens) && (tokens[i].Type == hclsyntax.TokenNewline || tokens[i].Type == hclsyntax.TokenComment) {
+	i := 0
+	for i < len(children) {
+		// Skip whitespace and comments, remembering where comments before a
+		// key start so they move with its entry
+		leadIdx := -1
+		for i < len(children) && isLineEndToken(children[i].Token) {
+			if children[i].Token.Type == hclsyntax.TokenComment && leadIdx == -1 {
+				leadIdx = i
+			}
 			i++
 		}
 
-		if i >= len(tokens) {
+		if i >= len(children) {
 			break
 		}
 
-		// Track brace level
-		if tokens[i].Type == hclsyntax.TokenOBrace {
-			braceLevel++
-		} else if tokens[i].Type == hclsyntax.TokenCBrace {
-			braceLevel--
-			if braceLevel == 0 {
-				break
-			}
+		// Look for key tokens
+		if !s.isKeyLikeToken(children[i].Token) {
+			i++
+			continue
 		}
-
-		// Look for key tokens at top level
-		if braceLevel == 1 && (tokens[i].Type == hclsyntax.TokenIdent || tokens[i].Type == hclsyntax.TokenQuotedLit) {
-			entry := s.parseObjectEntry(tokens, i)
-			if entry != nil {
-				entries = append(entries, *entry)
-				i = entry.EndIdx
-			} else {
-				i++
-			}
-		} else {
+		entry, ok := s.parseObjectEntry(children, i)
+		if !ok {
 			i++
+			continue
+		}
+		if leadIdx != -1 {
+			entry.Nodes = append(children[leadIdx:i:i], entry.Nodes...)
+			entry.MultiLine = isMultiLineEntry(entry.Nodes)
 		}
+		entries = append(entries, entry)
+		i = entry.EndIdx
 	}
 
 	return entries
 }
 
 // parseObjectEntry parses a single key-value pair starting at the given index
-func (s *Sorter) parseObjectEntry(tokens hclwrite.Tokens, startIdx int) *ObjectEntry {
-	if startIdx >= len(tokens) {
-		return nil
-	}
-
+func (s *Sorter) parseObjectEntry(children []*tokenNode, startIdx int) (ObjectEntry, bool) {
 	// Extract key
-	keyToken := tokens[startIdx]
-	key := s.extractKeyName(keyToken)
-	if key == "" {
-		return nil
+	key, ok := s.extractKeyName(children[startIdx])
+	if !ok {
+		return ObjectEntry{}, false
 	}
 
-	// Find the equals sign or colon
+	// Find the equals sign or colon, stopping at another key
 	equalIdx := -1
-	for i := startIdx + 1; i < len(tokens); i++ {
-		if tokens[i].Type == hclsyntax.TokenEqual || tokens[i].Type == hclsyntax.TokenColon {
+	for i := startIdx + 1; i < len(children); i++ {
+		tokenType := children[i].Token.Type
+		if tokenType == hclsyntax.TokenEqual || tokenType == hclsyntax.TokenColon {
 			equalIdx = i
 			break
 		}
-		// Stop if we hit another key or closing brace at the same level
-		if tokens[i].Type == hclsyntax.TokenIdent || tokens[i].Type == hclsyntax.TokenQuotedLit || tokens[i].Type == hclsyntax.TokenCBrace {
+		if s.isKeyLikeToken(children[i].Token) {
 			break
 		}
 	}
 
 	if equalIdx == -1 {
-		return nil
+		return ObjectEntry{}, false
 	}
 
 	// Find the end of the value
-	endIdx := s.findValueEnd(tokens, equalIdx+1)
-	if endIdx == -1 {
-		return nil
-	}
-
-	// Extract all tokens for this entry
-	entryTokens := make(hclwrite.Tokens, 0, endIdx-startIdx)
-	for i := startIdx; i < endIdx; i++ {
-		entryTokens = append(entryTokens, tokens[i])
-	}
-
-	// Recursively sort nested objects within this entry
-	entryTokens = s.recursiveSortTokens(entryTokens)
-
-	return &ObjectEntry{
-		Key:      key,
-		Tokens:   entryTokens,
-		StartIdx: startIdx,
-		EndIdx:   endIdx,
-	}
-}
-
-// recursiveSortTokens recursively sorts any nested objects within the token sequence
-func (s *Sorter) recursiveSortTokens(tokens hclwrite.Tokens) hclwrite.Tokens {
-	if len(tokens) == 0 {
-		return tokens
-	}
-
-	// Look for nested objects and arrays and sort them
-	result := make(hclwrite.Tokens, 0, len(tokens))
-	i := 0
-
-	for i < len(tokens) {
-		switch tokens[i].Type {
-		case hclsyntax.TokenOBrace:
-			// Found a nested object, extract and sort it
-			objEnd := s.findMatchingBrace(tokens, i)
-			if objEnd > i {
-				// Extract the object tokens
-				objTokens := tokens[i : objEnd+1]
-
-				// Try to sort this nested object
-				if sortedObj := s.sortObjectLiteral(objTokens); sortedObj != nil {
-					result = append(result, sortedObj...)
-				} else {
-					result = append(result, objTokens...)
-				}
-
-				i = objEnd + 1
-			} else {
-				result = append(result, tokens[i])
-				i++
-			}
-		case hclsyntax.TokenOBrack:
-			// Found an array, process its contents for nested objects
-			arrEnd := s.findMatchingBracket(tokens, i)
-			if arrEnd > i {
-				// Process array contents
-				arrayTokens := s.sortArrayContents(tokens[i : arrEnd+1])
-				result = append(result, arrayTokens...)
-				i = arrEnd + 1
-			} else {
-				result = append(result, tokens[i])
-				i++
-			}
-		default:
-			result = append(result, tokens[i])
-			i++
-		}
-	}
-
-	return result
-}
-
-// findMatchingBrace finds the closing brace that matches the opening brace at the given index
-func (s *Sorter) findMatchingBrace(tokens hclwrite.Tokens, openIdx int) int {
-	if openIdx >= len(tokens) || tokens[openIdx].Type != hclsyntax.TokenOBrace {
-		return -1
-	}
-
-	braceLevel := 1
-	for i := openIdx + 1; i < len(tokens); i++ {
-		switch tokens[i].Type {
-		case hclsyntax.TokenOBrace:
-			braceLevel++
-		case hclsyntax.TokenCBrace:
-			braceLevel--
-			if braceLevel == 0 {
-				return i
-			}
+	endIdx := s.findValueEnd(children, equalIdx+1)
+
+	// A comma ending the value is left out of the entry so separators can be
+	// written again once entries are sorted; the line ending after it is kept
+	commaIdx := -1
+	if children[endIdx-1].Token.Type == hclsyntax.TokenComma {
+		commaIdx = endIdx - 1
+		if endIdx < len(children) && isLineEndToken(children[endIdx].Token) {
+			endIdx++
 		}
 	}
 
-	return -1
-}
-
-// findMatchingBracket finds the closing bracket that matches the opening bracket at the given index
-func (s *Sorter) findMatchingBracket(tokens hclwrite.Tokens, openIdx int) int {
-	if openIdx >= len(tokens) || tokens[openIdx].Type != hclsyntax.TokenOBrack {
-		return -1
-	}
-
-	bracketLevel := 1
-	for i := openIdx + 1; i < len(tokens); i++ {
-		switch tokens[i].Type {
-		case hclsyntax.TokenOBrack:
-			bracketLevel++
-		case hclsyntax.TokenCBrack:
-			bracketLevel--
-			if bracketLevel == 0 {
-				return i
-			}
+	nodes := make([]*tokenNode, 0, endIdx-startIdx)
+	for i := startIdx; i < endIdx; i++ {
+		if i != commaIdx {
+			nodes = append(nodes, children[i])
 		}
 	}
 
-	return -1
+	return ObjectEntry{
+		Key:       key,
+		KeyToken:  children[startIdx].Token,
+		Nodes:     nodes,
+		Comma:     commaIdx != -1,
+		MultiLine: isMultiLineEntry(nodes),
+		EndIdx:    endIdx,
+	}, true
 }
 
-// sortArrayContents processes array contents and sorts any objects within the array
-func (s *Sorter) sortArrayContents(tokens hclwrite.Tokens) hclwrite.Tokens {
-	if len(tokens) < 3 { // Need at least [ content ]
-		return tokens
-	}
-
-	result := make(hclwrite.Tokens, 0, len(tokens))
-	result = append(result, tokens[0]) // Opening bracket
-
-	// Process the content between brackets
-	contentTokens := tokens[1 : len(tokens)-1]
-	contentResult := s.recursiveSortTokens(contentTokens)
-	result = append(result, contentResult...)
-
-	result = append(result, tokens[len(tokens)-1]) // Closing bracket
-	return result
-}
-
-// findValueEnd finds the end index of a value expression
-func (s *Sorter) findValueEnd(tokens hclwrite.Tokens, startIdx int) int {
-	braceLevel := 0
-	bracketLevel := 0
-	parenLevel := 0
-
-	for i := startIdx; i < len(tokens); i++ {
-		token := tokens[i]
+// findValueEnd finds the index in an object after the value starting at the
+// given index. A value ends at a comma, or at a line end followed by a key.
+func (s *Sorter) findValueEnd(children []*tokenNode, startIdx int) int {
+	for i := startIdx; i < len(children); i++ {
+		token := children[i].Token
 
 		switch token.Type {
-		case hclsyntax.TokenOBrace:
-			braceLevel++
-		case hclsyntax.TokenCBrace:
-			if braceLevel > 0 {
-				braceLevel--
-			} else {
-				// End of object
-				return i
-			}
-		case hclsyntax.TokenOBrack:
-			bracketLevel++
-		case hclsyntax.TokenCBrack:
-			bracketLevel--
-		case hclsyntax.TokenOParen:
-			parenLevel++
-		case hclsyntax.TokenCParen:
-			parenLevel--
-		case hclsyntax.TokenNewline:
-			// If we're at the top level and hit a newline, check if the next meaningful token is a key
-			if braceLevel == 0 && bracketLevel == 0 && parenLevel == 0 {
-				nextIdx := s.findNextNonWhitespace(tokens, i+1)
-				if nextIdx < len(tokens) && s.isKeyLikeToken(tokens[nextIdx]) {
+		case hclsyntax.TokenNewline, hclsyntax.TokenComment:
+			// At a line end, check if the next meaningful token is a key
+			if bytes.HasSuffix(token.Bytes, []byte("\n")) {
+				nextIdx := s.findNextNonWhitespace(children, i+1)
+				if nextIdx < len(children) && s.isKeyLikeToken(children[nextIdx].Token) {
 					return i + 1
 				}
 			}
 		case hclsyntax.TokenComma:
-			// If we're at the top level, comma ends the value
-			if braceLevel == 0 && bracketLevel == 0 && parenLevel == 0 {
-				return i + 1
-			}
+			return i + 1
 		}
 	}
 
-	return len(tokens)
+	// End of object; comments on the lines before the closing brace are not
+	// part of the value
+	end := len(children)
+	for end > startIdx && isLineEndToken(children[end-1].Token) {
+		end--
+	}
+	if end < len(children) {
+		return end + 1
+	}
+	return end
 }
 
 // isKeyLikeToken checks if a token could be the start of a key
 func (s *Sorter) isKeyLikeToken(token *hclwrite.Token) bool {
-	return token.Type == hclsyntax.TokenIdent || token.Type == hclsyntax.TokenQuotedLit
+	return token.Type == hclsyntax.TokenIdent || token.Type == hclsyntax.TokenOQuote
 }
 
-// findNextNonWhitespace finds the next non-whitespace token
-func (s *Sorter) findNextNonWhitespace(tokens hclwrite.Tokens, start int) int {
-	for i := start; i < len(tokens); i++ {
-		if tokens[i].Type != hclsyntax.TokenNewline && tokens[i].Type != hclsyntax.TokenComment {
+// findNextNonWhitespace finds the next node that is not a newline or comment
+func (s *Sorter) findNextNonWhitespace(nodes []*tokenNode, start int) int {
+	for i := start; i < len(nodes); i++ {
+		if !isLineEndToken(nodes[i].Token) {
 			return i
 		}
 	}
-	return len(tokens)
+	return len(nodes)
 }
 
-// extractKeyName extracts the key name from a token
-func (s *Sorter) extractKeyName(token *hclwrite.Token) string {
-	key := string(token.Bytes)
-	// Remove quotes if present
-	if token.Type == hclsyntax.TokenQuotedLit && len(key) >= 2 && key[0] == '"' && key[len(key)-1] == '"' {
-		key = key[1 : len(key)-1]
+// extractKeyName extracts the key name of a node, returning the key with
+// quotes and escapes removed. Quoted keys containing template sequences are
+// not extracted.
+func (s *Sorter) extractKeyName(node *tokenNode) (string, bool) {
+	if node.Token.Type == hclsyntax.TokenIdent {
+		return string(node.Token.Bytes), true
 	}
-	return key
-}
-
-// isMultiLineObjectEntry checks if an object entry spans multiple lines
-func (s *Sorter) isMultiLineObjectEntry(entry ObjectEntry) bool {
-	// Find the last non-newline token
-	lastNonNewlineIdx := -1
-	for i := len(entry.Tokens) - 1; i >= 0; i-- {
-		if entry.Tokens[i].Type != hclsyntax.TokenNewline {
-			lastNonNewlineIdx = i
-			break
-		}
+	if node.Token.Type != hclsyntax.TokenOQuote || node.Close == nil {
+		return "", false
 	}
 
-	// Count newlines that appear before the last non-newline token (internal newlines)
-	internalNewlines := 0
-	for i := 0; i <= lastNonNewlineIdx; i++ {
-		token := entry.Tokens[i]
-		if token.Type == hclsyntax.TokenNewline {
-			internalNewlines++
+	var literal strings.Builder
+	for _, child := range node.Children {
+		if child.Token.Type != hclsyntax.TokenQuotedLit {
+			return "", false
 		}
+		literal.Write(child.Token.Bytes)
 	}
 
-	// Multi-line ONLY if there are newlines within the value content
-	// Presence of brackets/braces alone doesn't make it multi-line if everything is on one line
-	// This allows single-line arrays like ["a", "b"] and objects like { key = "value" }
-	// to be grouped with other single-line entries
-	return internalNewlines > 0
+	key, err := strconv.Unquote(`"` + literal.String() + `"`)
+	if err != nil {
+		key = literal.String()
+	}
+	return key, true
 }
 
-// rebuildObjectTokens rebuilds the object with sorted entries
-func (s *Sorter) rebuildObjectTokens(tokens hclwrite.Tokens, entries []ObjectEntry, openBraceIdx int, needsSeparatorLine bool) hclwrite.Tokens {
-	result := make(hclwrite.Tokens, 0, len(tokens))
-
-	// Copy everything up to and including the opening brace
-	for i := 0; i <= openBraceIdx; i++ {
-		result = append(result, tokens[i])
+// isMultiLineEntry checks if an object entry spans multiple lines, counting
+// the newlines before its last node that is not a newline. Single-line lists
+// like ["a", "b"] and objects like { key = "value" } are grouped with other
+// single-line entries.
+func isMultiLineEntry(nodes []*tokenNode) bool {
+	end := len(nodes)
+	for end > 0 && nodes[end-1].Token.Type == hclsyntax.TokenNewline {
+		end--
 	}
+	return countNewlines(nodes[:end]) > 0
+}
+
+// rebuildObjectNodes returns the children of an object with its entries in
+// the order of its layout
+func (s *Sorter) rebuildObjectNodes(node *tokenNode, layout *objectLayout) []*tokenNode {
+	children := node.Children
+	entries := layout.entries
+	result := make([]*tokenNode, 0, len(children)+len(entries))
 
 	// Check if we need a newline after opening brace
 	needNewline := false
-	if openBraceIdx+1 < len(tokens) && tokens[openBraceIdx+1].Type == hclsyntax.TokenNewline {
-		result = append(result, &hclwrite.Token{
-			Type:  hclsyntax.TokenNewline,
-			Bytes: []byte("\n"),
-		})
+	if len(children) > 0 && children[0].Token.Type == hclsyntax.TokenNewline {
+		result = append(result, newTokenNode(hclsyntax.TokenNewline, "\n"))
 		needNewline = true
 	}
 
+	// Objects written on one line separate every entry with a comma, adding a
+	// trailing comma only when the original had one
+	inline := !needNewline && node.newlines == 0
+	trailingComma := !slices.ContainsFunc(entries, func(entry ObjectEntry) bool {
+		return !entry.Comma
+	})
+
 	// Count single-line entries (they all come first due to sorting)
 	singleLineCount := 0
 	for _, entry := range entries {
-		if s.isMultiLineObjectEntry(entry) {
+		if entry.MultiLine {
 			break // Found first multi-line entry, all remaining are multi-line
 		}
 		singleLineCount++
 	}
 
 	for i, entry := range entries {
-		isMultiLine := s.isMultiLineObjectEntry(entry)
-
 		// Add separator line between single-line and multi-line groups
-		if needsSeparatorLine && i == singleLineCount && singleLineCount > 0 {
-			result = append(result, &hclwrite.Token{
-				Type:  hclsyntax.TokenNewline,
-				Bytes: []byte("\n"),
-			})
+		if layout.grouped && layout.spacing && i == singleLineCount && singleLineCount > 0 {
+			result = append(result, newTokenNode(hclsyntax.TokenNewline, "\n"))
 		}
 
-		// Add proper indentation for multiline formatting
-		if needNewline && len(entry.Tokens) > 0 {
-			// Clean up leading and trailing newlines to ensure proper spacing
-			cleanedTokens := s.cleanLeadingAndTrailingNewlines(entry.Tokens)
-
-			// Preserve or add proper indentation
-			if len(cleanedTokens) > 0 {
-				firstToken := cleanedTokens[0]
-				if firstToken.SpacesBefore == 0 {
-					firstToken = &hclwrite.Token{
-						Type:         firstToken.Type,
-						Bytes:        firstToken.Bytes,
-						SpacesBefore: 2, // Standard indentation
-					}
-					result = append(result, firstToken)
-					result = append(result, cleanedTokens[1:]...)
-				} else {
-					result = append(result, cleanedTokens...)
-				}
+		if inline {
+			result = append(result, entry.Nodes...)
+			if i < len(entries)-1 || trailingComma {
+				result = append(result, newTokenNode(hclsyntax.TokenComma, ","))
 			}
 		} else {
-			// Clean up leading and trailing newlines to ensure proper spacing
-			cleanedTokens := s.cleanLeadingAndTrailingNewlines(entry.Tokens)
-			result = append(result, cleanedTokens...)
-		}
-
-		// Only add blank lines where needed, don't add regular newlines as entry tokens should handle that
-		if i < len(entries)-1 {
-			nextIsMultiLine := s.isMultiLineObjectEntry(entries[i+1])
-
-			// Add blank line between multi-line entries ONLY
-			// Single-line entries should be grouped together without blank lines
-			shouldAddBlankLine := isMultiLine && nextIsMultiLine
+			entryNodes := entry.Nodes
+			if entry.Comma {
+				entryNodes = withComma(entryNodes)
+			}
 
-			if shouldAddBlankLine {
-				result = append(result, &hclwrite.Token{
-					Type:  hclsyntax.TokenNewline,
-					Bytes: []byte("\n"),
-				})
+			// Clean up leading and trailing newlines to ensure proper spacing,
+			// indenting entries of multi-line objects that have no indentation
+			cleaned := s.cleanLeadingAndTrailingNewlines(entryNodes)
+			if needNewline && cleaned[0].Token.SpacesBefore == 0 {
+				first := *cleaned[0]
+				first.Token = &hclwrite.Token{
+					Type:         first.Token.Type,
+					Bytes:        first.Token.Bytes,
+					SpacesBefore: 2, // Standard indentation
+				}
+				result = append(result, &first)
+				result = append(result, cleaned[1:]...)
+			} else {
+				result = append(result, cleaned...)
 			}
 		}
-	}
 
-	// Find and add the closing brace and any trailing content
-	closeBraceIdx := s.findClosingBrace(tokens, openBraceIdx)
-	if closeBraceIdx >= 0 {
-		// Don't add extra newline before closing brace as entries already have newlines
+		// Add blank line between multi-line entries ONLY
+		// Single-line entries should be grouped together without blank lines
+		if layout.spacing && i < len(entries)-1 && entry.MultiLine && entries[i+1].MultiLine {
+			result = append(result, newTokenNode(hclsyntax.TokenNewline, "\n"))
+		}
+	}
 
-		// Add closing brace and everything after
-		for i := closeBraceIdx; i < len(tokens); i++ {
-			result = append(result, tokens[i])
+	// Keep comments between the last entry and the closing brace
+	footerIdx := 0
+	for _, entry := range entries {
+		footerIdx = max(footerIdx, entry.EndIdx)
+	}
+	for _, child := range children[footerIdx:] {
+		if child.Token.Type == hclsyntax.TokenComment {
+			result = append(result, child)
 		}
 	}
 
 	return result
 }
 
-// findClosingBrace finds the matching closing brace for an opening brace
-func (s *Sorter) findClosingBrace(tokens hclwrite.Tokens, openBraceIdx int) int {
-	braceLevel := 1
-	for i := openBraceIdx + 1; i < len(tokens); i++ {
-		switch tokens[i].Type {
-		case hclsyntax.TokenOBrace:
-			braceLevel++
-		case hclsyntax.TokenCBrace:
-			braceLevel--
-			if braceLevel == 0 {
-				return i
-			}
-		}
+// getAttributeSource returns the trimmed source text of an attribute in a block
+func (s *Sorter) getAttributeSource(block *hclwrite.Block, name string) string {
+	attr := block.Body().GetAttribute(name)
+	if attr == nil {
+		return ""
 	}
-	return -1
+	return strings.TrimSpace(string(attr.Expr().BuildTokens(nil).Bytes()))
 }
 
 // getValidationErrorMessage extracts the error_message from a validation block
@@ -1158,47 +1251,49 @@ func (s *Sorter) getDynamicContentSortKey(block *hclwrite.Block) string {
 	return ""
 }
 
-// cleanTrailingNewlines removes extra trailing newlines, keeping only one
-// cleanLeadingAndTrailingNewlines removes leading and trailing newlines from tokens
-// This ensures that entries don't have unwanted blank lines around them
-func (s *Sorter) cleanLeadingAndTrailingNewlines(tokens hclwrite.Tokens) hclwrite.Tokens {
-	if len(tokens) == 0 {
-		return tokens
+// withComma returns entry nodes with a comma placed after the value, before
+// any trailing comment or newline
+func withComma(nodes []*tokenNode) []*tokenNode {
+	valueEnd := len(nodes)
+	for valueEnd > 0 && isLineEndToken(nodes[valueEnd-1].Token) {
+		valueEnd--
 	}
 
-	// Find first non-newline token
-	firstNonNewlineIdx := -1
-	for i := 0; i < len(tokens); i++ {
-		if tokens[i].Type != hclsyntax.TokenNewline {
-			firstNonNewlineIdx = i
+	result := make([]*tokenNode, 0, len(nodes)+1)
+	result = append(result, nodes[:valueEnd]...)
+	result = append(result, newTokenNode(hclsyntax.TokenComma, ","))
+	return append(result, nodes[valueEnd:]...)
+}
+
+// cleanLeadingAndTrailingNewlines removes leading and trailing newlines from nodes
+// This ensures that entries don't have unwanted blank lines around them
+func (s *Sorter) cleanLeadingAndTrailingNewlines(nodes []*tokenNode) []*tokenNode {
+	// Find first non-newline node
+	first := -1
+	for i, node := range nodes {
+		if node.Token.Type != hclsyntax.TokenNewline {
+			first = i
 			break
 		}
 	}
 
-	if firstNonNewlineIdx == -1 {
-		// All tokens are newlines, keep just one
-		return hclwrite.Tokens{&hclwrite.Token{
-			Type:  hclsyntax.TokenNewline,
-			Bytes: []byte("\n"),
-		}}
+	if first == -1 {
+		// All nodes are newlines, keep just one
+		return []*tokenNode{newTokenNode(hclsyntax.TokenNewline, "\n")}
 	}
 
-	// Find last non-newline token
-	lastNonNewlineIdx := -1
-	for i := len(tokens) - 1; i >= 0; i-- {
-		if tokens[i].Type != hclsyntax.TokenNewline {
-			lastNonNewlineIdx = i
-			break
-		}
+	// Find last non-newline node
+	last := len(nodes) - 1
+	for nodes[last].Token.Type == hclsyntax.TokenNewline {
+		last--
 	}
 
-	// Extract the tokens between first and last non-newline (inclusive) and add exactly one trailing newline
-	result := make(hclwrite.Tokens, 0, lastNonNewlineIdx-firstNonNewlineIdx+2)
-	result = append(result, tokens[firstNonNewlineIdx:lastNonNewlineIdx+1]...)
-	result = append(result, &hclwrite.Token{
-		Type:  hclsyntax.TokenNewline,
-		Bytes: []byte("\n"),
-	})
-
-	return result
+	// Keep the nodes between first and last non-newline (inclusive) and add exactly one trailing newline
+	result := make([]*tokenNode, 0, last-first+2)
+	result = append(result, nodes[first:last+1]...)
+	if token := nodes[last].Token; token.Type == hclsyntax.TokenComment && bytes.HasSuffix(token.Bytes, []byte("\n")) {
+		// Line comments already end with a newline
+		return result
+	}
+	return append(result, newTokenNode(hclsyntax.TokenNewline, "\n"))
 }
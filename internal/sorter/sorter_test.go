@@ -1,6 +1,8 @@
 package sorter
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/maxexcloo/tofusort/internal/parser"
@@ -14,9 +16,9 @@ func TestSortSimpleProvider(t *testing.T) {
 }`
 
 	expected := `provider "test" {
+  alias    = "test"
   for_each = var.test
 
-  alias    = "test"
   endpoint = "https://example.com"
 }
 `
@@ -36,6 +38,7 @@ provider "a" {
 	expected := `provider "a" {
   name = "a"
 }
+
 provider "z" {
   name = "z"
 }
@@ -60,6 +63,7 @@ terraform {
 	expected := `terraform {
   required_version = ">= 1.0"
 }
+
 variable "test" {
   type = string
 }
@@ -399,6 +403,81 @@ servers = [
 	testSorting(t, input, expected)
 }
 
+func TestTopLevelAttributesWithBlocks(t *testing.T) {
+	input := `region = "us-west-2"
+name   = "web"
+
+resource "aws_instance" "web" {
+  ami = "ami-12345"
+}
+`
+
+	expected := `name   = "web"
+region = "us-west-2"
+
+resource "aws_instance" "web" {
+  ami = "ami-12345"
+}
+`
+
+	testSorting(t, input, expected)
+}
+
+func TestBodyComments(t *testing.T) {
+	input := `resource "aws_instance" "web" { # managed by hand
+  tags = {}
+  ami  = "ami-12345"
+}
+
+module "network" { # shared
+  dynamic "subnet" {
+    content {}
+  }
+  source = "./network"
+}
+
+variable "region" {
+  type = string
+  # deprecated
+}
+`
+
+	expected := `variable "region" {
+  type = string
+  # deprecated
+}
+
+resource "aws_instance" "web" { # managed by hand
+  ami  = "ami-12345"
+  tags = {}
+}
+
+module "network" { # shared
+  source = "./network"
+
+  dynamic "subnet" {
+    content {}
+  }
+}
+`
+
+	testSorting(t, input, expected)
+}
+
+func TestTfvarsAuthoredAttributeOrder(t *testing.T) {
+	input := `region = "us-west-2"
+name   = "web"
+
+tags = {
+  Owner = "team"
+}
+`
+
+	config := DefaultConfig()
+	config.DisabledRules = []string{RuleAttributeOrder}
+	testSortingWithConfig(t, config, input, input)
+}
+
 func TestComplexExpressionPreservation(t *testing.T) {
 	input := `locals {
   servers = {
@@ -430,8 +509,8 @@ func TestComplexExpressionPreservation(t *testing.T) {
   tags = merge(
     var.common_tags,
     {
-      Environment = "prod"
       Application = "web"
+      Environment = "prod"
     }
   )
 }
@@ -440,19 +519,1135 @@ func TestComplexExpressionPreservation(t *testing.T) {
 	testSorting(t, input, expected)
 }
 
-func testSorting(t *testing.T, input, expected string) {
-	p := parser.New()
-	s := New()
+func TestExpressionClassification(t *testing.T) {
+	input := `locals {
+  app = {
+    name        = "web"
+    description = "used for the app"
+  }
 
-	file, err := p.ParseFile([]byte(input))
-	if err != nil {
-		t.Fatalf("Failed to parse input: %v", err)
-	}
+  policy = jsonencode({
+    Version   = "2012-10-17"
+    Statement = []
+  })
 
-	s.SortFile(file)
+  settings = var.enabled ? { size = 2, name = "on" } : tomap({ b = 1, a = 2 })
 
-	result := string(p.FormatFile(file))
-	if result != expected {
-		t.Errorf("Sorting failed.\nExpected:\n%s\nGot:\n%s", expected, result)
+  by_name = { for k, v in var.items : k => { z = v, a = k } }
+  names   = var.items[*].name
+  label   = "${var.prefix}-app"
+  dynamic = { (var.key) = 1, b = 2 }
+  other   = lookup({ z = 1, a = 2 }, "a")
+}`
+
+	expected := `locals {
+  by_name  = { for k, v in var.items : k => { z = v, a = k } }
+  dynamic  = { (var.key) = 1, b = 2 }
+  label    = "${var.prefix}-app"
+  names    = var.items[*].name
+  other    = lookup({ z = 1, a = 2 }, "a")
+  settings = var.enabled ? { name = "on", size = 2 } : tomap({ a = 2, b = 1 })
+
+  app = {
+    description = "used for the app"
+    name        = "web"
+  }
+
+  policy = jsonencode({
+    Version   = "2012-10-17"
+    Statement = []
+  })
+}
+`
+
+	testSorting(t, input, expected)
+}
+
+func TestEncodedObjectKeys(t *testing.T) {
+	input := `resource "aws_iam_policy" "example" {
+  policy = jsonencode({
+    # Statements are evaluated in order
+    Statement = [
+      {
+        Resource = "*"
+        Effect   = "Allow"
+        Action   = ["s3:PutObject", "s3:GetObject"]
+      },
+      {
+        Effect = "Deny"
+        Action = "s3:DeleteObject"
+      },
+    ]
+    Version = "2012-10-17"
+    # Footer comment
+  })
+}`
+
+	expected := `resource "aws_iam_policy" "example" {
+  policy = jsonencode({
+    Version = "2012-10-17"
+
+    # Statements are evaluated in order
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = ["s3:PutObject", "s3:GetObject"]
+        Resource = "*"
+      },
+      {
+        Effect = "Deny"
+        Action = "s3:DeleteObject"
+      },
+    ]
+    # Footer comment
+  })
+}
+`
+
+	testSorting(t, input, expected)
+}
+
+func TestCustomEncodedKeyOrder(t *testing.T) {
+	config := DefaultConfig()
+	config.EncodedKeyOrder = []string{"name"}
+
+	input := `locals {
+  manifest = yamlencode({ replicas = 2, name = "web", image = "nginx" })
+  other    = { replicas = 2, name = "web", image = "nginx" }
+}`
+
+	expected := `locals {
+  manifest = yamlencode({ name = "web", image = "nginx", replicas = 2 })
+  other    = { image = "nginx", name = "web", replicas = 2 }
+}
+`
+
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestJSONHeredocSorting(t *testing.T) {
+	config := DefaultConfig()
+	config.SortJSONHeredocs = true
+
+	input := `resource "aws_iam_policy" "example" {
+  policy = <<-POLICY
+    {
+        "Statement": [
+            {"Resource": "*", "Effect": "Allow", "Action": ["s3:PutObject", "s3:GetObject"]}
+        ],
+        "Version": "2012-10-17"
+    }
+  POLICY
+
+  tags = <<EOF
+{"team": "web", "env": "prod"}
+EOF
+
+  settings = <<JSON
+{
+  "z": {"y": 1, "b": 2},
+  "l": [1, 2]
+}
+JSON
+
+  template = <<EOF
+{"name": "${var.name}", "env": "prod"}
+EOF
+}`
+
+	expected := `resource "aws_iam_policy" "example" {
+  policy   = <<-POLICY
+    {
+        "Version": "2012-10-17",
+        "Statement": [
+            {"Effect": "Allow", "Action": ["s3:PutObject", "s3:GetObject"], "Resource": "*"}
+        ]
+    }
+  POLICY
+  settings = <<JSON
+{
+  "l": [1, 2],
+  "z": {"b": 2, "y": 1}
+}
+JSON
+  tags     = <<EOF
+{"env": "prod", "team": "web"}
+EOF
+  template = <<EOF
+{"name": "${var.name}", "env": "prod"}
+EOF
+}
+`
+
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestLifecycleOrder(t *testing.T) {
+	input := `resource "aws_instance" "web" {
+  lifecycle {
+    replace_triggered_by  = [aws_ami.web.id]
+    postcondition {
+      condition     = self.public_ip != ""
+      error_message = "Instance must have a public IP."
+    }
+    ignore_changes        = [tags]
+    precondition {
+      condition     = var.ami != ""
+      error_message = "AMI must be set."
+    }
+    create_before_destroy = true
+  }
+}`
+
+	expected := `resource "aws_instance" "web" {
+  lifecycle {
+    create_before_destroy = true
+    ignore_changes        = [tags]
+    replace_triggered_by  = [aws_ami.web.id]
+
+    postcondition {
+      condition     = self.public_ip != ""
+      error_message = "Instance must have a public IP."
+    }
+
+    precondition {
+      condition     = var.ami != ""
+      error_message = "AMI must be set."
+    }
+  }
+}
+`
+
+	testSorting(t, input, expected)
+}
+
+func TestProvisionerOrder(t *testing.T) {
+	input := `resource "aws_instance" "web" {
+  provisioner "remote-exec" {
+    inline = ["sudo systemctl start app"]
+  }
+
+  provisioner "file" {
+    source      = "app.conf"
+    destination = "/etc/app.conf"
+  }
+
+  connection {
+    type = "ssh"
+    host = self.public_ip
+  }
+
+  provider      = aws.west
+  instance_type = "t3.micro"
+  count         = 2
+}`
+
+	expected := `resource "aws_instance" "web" {
+  count    = 2
+  provider = aws.west
+
+  instance_type = "t3.micro"
+
+  connection {
+    host = self.public_ip
+    type = "ssh"
+  }
+
+  provisioner "remote-exec" {
+    inline = ["sudo systemctl start app"]
+  }
+
+  provisioner "file" {
+    destination = "/etc/app.conf"
+    source      = "app.conf"
+  }
+}
+`
+
+	testSorting(t, input, expected)
+}
+
+func TestOutputArgumentOrder(t *testing.T) {
+	input := `output "id" {
+  depends_on  = [aws_instance.example]
+  sensitive   = true
+  value       = aws_instance.example.id
+  description = "Instance ID"
+
+  precondition {
+    condition     = aws_instance.example.id != ""
+    error_message = "Missing ID."
+  }
+}`
+
+	expected := `output "id" {
+  description = "Instance ID"
+  value       = aws_instance.example.id
+  sensitive   = true
+
+  depends_on = [aws_instance.example]
+
+  precondition {
+    condition     = aws_instance.example.id != ""
+    error_message = "Missing ID."
+  }
+}
+`
+
+	testSorting(t, input, expected)
+}
+
+func TestModuleArgumentOrder(t *testing.T) {
+	input := `module "vpc" {
+  name       = "main"
+  depends_on = [module.base]
+  count      = 2
+  cidr       = "10.0.0.0/16"
+  version    = "5.0.0"
+  providers = {
+    aws = aws.primary
+  }
+  source = "terraform-aws-modules/vpc/aws"
+}`
+
+	expected := `module "vpc" {
+  source  = "terraform-aws-modules/vpc/aws"
+  version = "5.0.0"
+
+  providers = {
+    aws = aws.primary
+  }
+
+  count = 2
+
+  cidr = "10.0.0.0/16"
+  name = "main"
+
+  depends_on = [module.base]
+}
+`
+
+	testSorting(t, input, expected)
+}
+
+func TestTerraformBlockOrder(t *testing.T) {
+	input := `terraform {
+  encryption {
+    state {
+      method = method.aes_gcm.main
+    }
+    plan {
+      method = method.aes_gcm.main
+    }
+    method "aes_gcm" "main" {
+      keys = key_provider.pbkdf2.chain
+    }
+    key_provider "pbkdf2" "base" {
+      passphrase = var.passphrase
+    }
+    key_provider "pbkdf2" "chain" {
+      chain = key_provider.pbkdf2.base
+    }
+  }
+  backend "s3" {
+    key    = "state"
+    bucket = "b"
+  }
+  required_providers {
+    random = {
+      version = "~> 3.0"
+      source  = "hashicorp/random"
+    }
+    aws = {
+      configuration_aliases = [aws.east]
+      version               = "~> 5.0"
+      source                = "hashicorp/aws"
+    }
+  }
+  required_version = ">= 1.6"
+}`
+
+	expected := `terraform {
+  required_version = ">= 1.6"
+
+  required_providers {
+    aws = {
+      source                = "hashicorp/aws"
+      version               = "~> 5.0"
+      configuration_aliases = [aws.east]
+    }
+
+    random = {
+      source  = "hashicorp/random"
+      version = "~> 3.0"
+    }
+  }
+
+  backend "s3" {
+    bucket = "b"
+    key    = "state"
+  }
+
+  encryption {
+    key_provider "pbkdf2" "base" {
+      passphrase = var.passphrase
+    }
+
+    key_provider "pbkdf2" "chain" {
+      chain = key_provider.pbkdf2.base
+    }
+
+    method "aes_gcm" "main" {
+      keys = key_provider.pbkdf2.chain
+    }
+
+    state {
+      method = method.aes_gcm.main
+    }
+
+    plan {
+      method = method.aes_gcm.main
+    }
+  }
+}
+`
+
+	testSorting(t, input, expected)
+}
+
+func TestRefactoringBlockOrder(t *testing.T) {
+	input := `moved {
+  to   = aws_instance.c
+  from = aws_instance.b
+}
+
+removed {
+  from = aws_instance.z
+}
+
+import {
+  id = "i-2"
+  to = aws_instance.b
+}
+
+moved {
+  to   = aws_instance.b
+  from = aws_instance.a
+}
+
+removed {
+  from = aws_instance.y
+}
+
+import {
+  to = aws_instance.a
+  id = "i-1"
+}
+
+resource "aws_instance" "c" {
+  ami = "ami-12345"
+}`
+
+	expected := `resource "aws_instance" "c" {
+  ami = "ami-12345"
+}
+
+import {
+  to = aws_instance.a
+  id = "i-1"
+}
+
+import {
+  to = aws_instance.b
+  id = "i-2"
+}
+
+moved {
+  from = aws_instance.b
+  to   = aws_instance.c
+}
+
+moved {
+  from = aws_instance.a
+  to   = aws_instance.b
+}
+
+removed {
+  from = aws_instance.y
+}
+
+removed {
+  from = aws_instance.z
+}
+`
+
+	testSorting(t, input, expected)
+}
+
+func TestTopologicalMovedOrder(t *testing.T) {
+	input := `moved {
+  from = aws_instance.c
+  to   = aws_instance.d
+}
+
+moved {
+  from = aws_instance.b
+  to   = aws_instance.c
+}
+
+moved {
+  from = aws_instance.x
+  to   = aws_instance.y
+}
+
+moved {
+  from = aws_instance.a
+  to   = aws_instance.b
+}`
+
+	expected := `moved {
+  from = aws_instance.x
+  to   = aws_instance.y
+}
+
+moved {
+  from = aws_instance.a
+  to   = aws_instance.b
+}
+
+moved {
+  from = aws_instance.b
+  to   = aws_instance.c
+}
+
+moved {
+  from = aws_instance.c
+  to   = aws_instance.d
+}
+`
+
+	config := DefaultConfig()
+	config.MovedOrder = MovedOrderTopological
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestNaturalCollation(t *testing.T) {
+	input := `resource "aws_instance" "web10" {
+  ami = "ami-12345"
+}
+
+resource "aws_instance" "web2" {
+  ami = "ami-12345"
+}
+
+locals {
+  ports = {
+    "10"   = "ten"
+    "9"    = "nine"
+    "a\"b" = "quoted"
+    Zone   = "zone"
+    app    = "app"
+  }
+}`
+
+	expected := `locals {
+  ports = {
+    "9"    = "nine"
+    "10"   = "ten"
+    "a\"b" = "quoted"
+    app    = "app"
+    Zone   = "zone"
+  }
+}
+
+resource "aws_instance" "web2" {
+  ami = "ami-12345"
+}
+
+resource "aws_instance" "web10" {
+  ami = "ami-12345"
+}
+`
+
+	config := DefaultConfig()
+	config.Collation = CollationNaturalCaseInsensitive
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestQuotedObjectKeys(t *testing.T) {
+	input := `locals {
+  headers = {
+    "X-Request-Id" = "abc"
+    "Accept"       = "*/*"
+    ""             = "empty"
+  }
+}`
+
+	expected := `locals {
+  headers = {
+    ""             = "empty"
+    "Accept"       = "*/*"
+    "X-Request-Id" = "abc"
+  }
+}
+`
+
+	testSorting(t, input, expected)
+}
+
+func TestLocalsDependencyOrder(t *testing.T) {
+	input := `locals {
+  name_prefix = "${local.project}-${local.environment}"
+  environment = "prod"
+  tags = {
+    Name = local.name_prefix
+    Team = local.team
+  }
+  project = "app"
+  team    = "platform"
+}`
+
+	expected := `locals {
+  environment = "prod"
+  project     = "app"
+  name_prefix = "${local.project}-${local.environment}"
+  team        = "platform"
+
+  tags = {
+    Name = local.name_prefix
+    Team = local.team
+  }
+}
+`
+
+	config := DefaultConfig()
+	config.LocalsOrder = LocalsOrderDependency
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestLocalsDependencyCycle(t *testing.T) {
+	input := `locals {
+  b = local.a
+  c = "constant"
+  a = local.b
+}`
+
+	p := parser.New()
+	config := DefaultConfig()
+	config.LocalsOrder = LocalsOrderDependency
+	file, err := p.ParseFile([]byte(input), "main.tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diags := NewWithConfig(config).SortFile(file)
+	if len(diags) != 1 || !strings.Contains(diags[0].Detail, "local.a -> local.b -> local.a") {
+		t.Fatalf("SortFile() diagnostics = %v, want a single a/b cycle", diags)
+	}
+	if subject := diags[0].Subject; subject == nil || subject.String() != ":4,3-14" {
+		t.Errorf("SortFile() diagnostic subject = %v, want the range of local a", subject)
+	}
+
+	expected := `locals {
+  c = "constant"
+  a = local.b
+  b = local.a
+}
+`
+	if result := string(p.FormatFile(file)); result != expected {
+		t.Errorf("Sorting failed.\nExpected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestSetListSorting(t *testing.T) {
+	input := `resource "aws_instance" "web" {
+  for_each        = toset(["b", "a", "c"])
+  security_groups = ["sg-2", "sg-1"]
+  subnet_ids      = ["subnet-2", "subnet-1"]
+  depends_on = [
+    # networking first
+    aws_vpc.main,
+    aws_subnet.b, # second subnet
+    aws_subnet.a,
+  ]
+
+  lifecycle {
+    ignore_changes = [tags, ami]
+  }
+}`
+
+	expected := `resource "aws_instance" "web" {
+  for_each = toset(["a", "b", "c"])
+
+  security_groups = ["sg-1", "sg-2"]
+  subnet_ids      = ["subnet-2", "subnet-1"]
+
+  depends_on = [
+    aws_subnet.a,
+    aws_subnet.b, # second subnet
+    # networking first
+    aws_vpc.main,
+  ]
+
+  lifecycle {
+    ignore_changes = [ami, tags]
+  }
+}
+`
+
+	config := DefaultConfig()
+	config.SortSets = true
+	config.SetAttributes = append(config.SetAttributes, "resource.aws_instance.security_groups")
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestSetListLeadingComment(t *testing.T) {
+	input := `locals {
+  zones = toset([/* lead */ "z", "y"])
+  names = toset([ # lead
+    "z",
+    "y",
+  ])
+}`
+
+	expected := `locals {
+  zones = toset(["y", /* lead */ "z"])
+
+  names = toset(["y",
+    # lead
+    "z",
+  ])
+}
+`
+
+	config := DefaultConfig()
+	config.SortSets = true
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestSetListDuplicates(t *testing.T) {
+	input := `locals {
+  zones = toset(["b", "a", "b"])
+}`
+
+	p := parser.New()
+	config := DefaultConfig()
+	config.SortSets = true
+	file, err := p.ParseFile([]byte(input), "main.tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diags := NewWithConfig(config).SortFile(file)
+	if len(diags) != 1 || !strings.Contains(diags[0].Detail, `"b" appears more than once`) {
+		t.Errorf("SortFile() diagnostics = %v, want a duplicate \"b\" warning", diags)
+	}
+}
+
+func TestCustomArgumentOrder(t *testing.T) {
+	input := `resource "aws_instance" "example" {
+  tags          = {}
+  instance_type = "t3.micro"
+  ami           = "ami-12345"
+  name          = "example"
+}`
+
+	expected := `resource "aws_instance" "example" {
+  name          = "example"
+  ami           = "ami-12345"
+  instance_type = "t3.micro"
+
+  tags = {}
+}
+`
+
+	config := DefaultConfig()
+	config.ArgumentOrder["resource"] = ArgumentOrder{
+		First: []string{"name"},
+		Last:  []string{"tags"},
+	}
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestSections(t *testing.T) {
+	input := `resource "aws_instance" "web" { # web server
+  tags = {}
+  ami  = "ami-12345"
+
+  # --- networking ---
+  vpc_security_group_ids = ["sg-1"]
+  subnet_id              = "subnet-1"
+  ebs_block_device {
+    device_name = "/dev/sdb"
+  }
+  count = 2
+
+  # The root volume
+  root_block_device {
+    volume_size = 20
+  }
+  # Sizing
+  instance_type  = "t3.micro"
+  cpu_core_count = 2
+  # Keep last
+}
+`
+
+	expected := `resource "aws_instance" "web" { # web server
+  ami  = "ami-12345"
+  tags = {}
+
+  # --- networking ---
+  count = 2
+
+  subnet_id              = "subnet-1"
+  vpc_security_group_ids = ["sg-1"]
+
+  ebs_block_device {
+    device_name = "/dev/sdb"
+  }
+
+  # The root volume
+  root_block_device {
+    volume_size = 20
+  }
+  # Sizing
+  cpu_core_count = 2
+  instance_type  = "t3.micro"
+  # Keep last
+}
+`
+
+	config := DefaultConfig()
+	config.Sections = true
+	testSortingWithConfig(t, config, input, expected)
+
+	input = `# Region
+
+zone   = "a"
+region = "us-west-2"
+# Sizing
+size  = 2
+count = 1
+`
+
+	expected = `# Region
+
+region = "us-west-2"
+zone   = "a"
+# Sizing
+count = 1
+size  = 2
+`
+
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestObserverRecordsChanges(t *testing.T) {
+	input := `resource "aws_instance" "web" {
+  depends_on = [aws_vpc.main]
+  ami        = "ami-12345"
+}
+
+data "aws_ami" "ubuntu" {
+  most_recent = true
+}
+
+locals {
+  region = "us-east-1"
+}
+`
+
+	p := parser.New()
+	file, err := p.ParseFile([]byte(input), "main.tf")
+	if err != nil {
+		t.Fatalf("Failed to parse input: %v", err)
+	}
+
+	var changes []Change
+	s := New()
+	s.SetObserver(ObserverFunc(func(change Change) {
+		changes = append(changes, change)
+	}))
+	s.SortFile(file)
+
+	expected := []Change{
+		{Kind: KindBlock, Address: "locals", OldLine: 10, NewLine: 1, RuleID: RuleBlockOrder, Rule: "block-type order: locals first"},
+		{Kind: KindAttribute, Address: "local.region", OldLine: 11, NewLine: 2, RuleID: RuleAttributeOrder, Rule: "single-line attribute group"},
+		{Kind: KindBlock, Address: "data.aws_ami.ubuntu", OldLine: 6, NewLine: 6, RuleID: RuleBlockOrder, Rule: "block-type order: locals before data"},
+		{Kind: KindAttribute, Address: "data.aws_ami.ubuntu.most_recent", OldLine: 7, NewLine: 7, RuleID: RuleAttributeOrder, Rule: "single-line attribute group"},
+		{Kind: KindBlock, Address: "aws_instance.web", OldLine: 1, NewLine: 11, RuleID: RuleBlockOrder, Rule: "block-type order: data before resource"},
+		{Kind: KindAttribute, Address: "aws_instance.web.ami", OldLine: 3, NewLine: 12, RuleID: RuleAttributeOrder, Rule: "single-line attribute group"},
+		{Kind: KindAttribute, Address: "aws_instance.web.depends_on", OldLine: 2, NewLine: 14, RuleID: RuleMetaArguments, Rule: "late meta-argument depends_on"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Observed %d changes, want %d: %+v", len(changes), len(expected), changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Change %d = %+v, want %+v", i, changes[i], expected[i])
+		}
+	}
+}
+
+func TestDisabledRules(t *testing.T) {
+	input := `resource "aws_instance" "web" {
+  tags = {
+    Name = "web"
+    Env  = "prod"
+  }
+  count      = 2
+  ami        = "ami-12345"
+  depends_on = [aws_vpc.main]
+}
+
+data "aws_ami" "ubuntu" {
+  most_recent = true
+}
+`
+
+	tests := []struct {
+		name     string
+		disabled []string
+		expected string
+	}{
+		{
+			name:     "block order and object keys",
+			disabled: []string{RuleBlockOrder, RuleObjectKeys},
+			expected: `resource "aws_instance" "web" {
+  count = 2
+
+  ami = "ami-12345"
+
+  tags = {
+    Name = "web"
+    Env  = "prod"
+  }
+
+  depends_on = [aws_vpc.main]
+}
+
+data "aws_ami" "ubuntu" {
+  most_recent = true
+}
+`,
+		},
+		{
+			name:     "attribute order and meta-arguments",
+			disabled: []string{RuleAttributeOrder, RuleMetaArguments},
+			expected: `data "aws_ami" "ubuntu" {
+  most_recent = true
+}
+
+resource "aws_instance" "web" {
+  tags = {
+    Env  = "prod"
+    Name = "web"
+  }
+
+  count      = 2
+  ami        = "ami-12345"
+  depends_on = [aws_vpc.main]
+}
+`,
+		},
+		{
+			name:     "spacing",
+			disabled: []string{RuleSpacing},
+			expected: `data "aws_ami" "ubuntu" {
+  most_recent = true
+}
+
+resource "aws_instance" "web" {
+  count = 2
+  ami   = "ami-12345"
+  tags = {
+    Env  = "prod"
+    Name = "web"
+  }
+  depends_on = [aws_vpc.main]
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.DisabledRules = tt.disabled
+			testSortingWithConfig(t, config, input, tt.expected)
+		})
+	}
+}
+
+func TestDynamicBlocksWithoutNestedBlocks(t *testing.T) {
+	input := `resource "aws_security_group" "web" {
+  dynamic "ingress" {
+    for_each = var.web_ports
+    content {
+      from_port = ingress.value
+    }
+  }
+  timeouts {
+    create = "5m"
+  }
+  dynamic "egress" {
+    for_each = var.ports
+    content {
+      to_port = egress.value
+    }
+  }
+}`
+
+	expected := `resource "aws_security_group" "web" {
+  dynamic "egress" {
+    for_each = var.ports
+
+    content {
+      to_port = egress.value
+    }
+  }
+
+  timeouts {
+    create = "5m"
+  }
+
+  dynamic "ingress" {
+    for_each = var.web_ports
+
+    content {
+      from_port = ingress.value
+    }
+  }
+}
+`
+
+	config := DefaultConfig()
+	config.DisabledRules = []string{RuleNestedBlocks}
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestBodiesUnchangedWithoutBodyRules(t *testing.T) {
+	input := `resource "aws_instance" "web" {
+  tags = {
+    Name = "web"
+    Env  = "prod"
+  }
+
+  lifecycle {
+    prevent_destroy = true
+  }
+  ami   = "ami-12345"
+  count = 2
+}
+
+data "aws_ami" "ubuntu" {
+  most_recent = true
+}
+`
+
+	expected := `data "aws_ami" "ubuntu" {
+  most_recent = true
+}
+
+resource "aws_instance" "web" {
+  tags = {
+    Name = "web"
+    Env  = "prod"
+  }
+
+  lifecycle {
+    prevent_destroy = true
+  }
+  ami   = "ami-12345"
+  count = 2
+}
+`
+
+	config := DefaultConfig()
+	for _, rule := range Rules() {
+		if rule.scope()&(bodyScope|objectScope) != 0 {
+			config.DisabledRules = append(config.DisabledRules, rule.ID())
+		}
+	}
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestAuthoredLabelOrder(t *testing.T) {
+	input := `resource "aws_instance" "web" {
+  ami = "ami-12345"
+}
+
+variable "zone" {}
+
+resource "aws_eip" "web" {
+  instance = aws_instance.web.id
+}
+
+variable "region" {}
+`
+
+	expected := `variable "zone" {}
+
+variable "region" {}
+
+resource "aws_instance" "web" {
+  ami = "ami-12345"
+}
+
+resource "aws_eip" "web" {
+  instance = aws_instance.web.id
+}
+`
+
+	config := DefaultConfig()
+	config.LabelOrder = LabelOrderAuthored
+	testSortingWithConfig(t, config, input, expected)
+}
+
+func TestCheckIdempotent(t *testing.T) {
+	p := parser.New()
+	s := New()
+
+	if err := s.CheckIdempotent(p, []byte("a = 1\nz = 2\n"), "main.tf"); err != nil {
+		t.Errorf("CheckIdempotent() error = %v for sorted output", err)
+	}
+
+	err := s.CheckIdempotent(p, []byte("z = 2\na = 1\n"), "main.tf")
+	var notIdempotent *NotIdempotentError
+	if !errors.As(err, &notIdempotent) {
+		t.Fatalf("CheckIdempotent() error = %v, want *NotIdempotentError", err)
+	}
+	if string(notIdempotent.Second) != "a = 1\nz = 2\n" {
+		t.Errorf("CheckIdempotent() second pass = %q", notIdempotent.Second)
+	}
+
+	var parseErr *parser.ParseError
+	if err := s.CheckIdempotent(p, []byte("a = \n"), "main.tf"); !errors.As(err, &parseErr) {
+		t.Errorf("CheckIdempotent() error = %v, want *parser.ParseError", err)
+	}
+}
+
+func testSorting(t *testing.T, input, expected string) {
+	testSortingWithConfig(t, DefaultConfig(), input, expected)
+}
+
+func testSortingWithConfig(t *testing.T, config Config, input, expected string) {
+	p := parser.New()
+	s := NewWithConfig(config)
+
+	file, err := p.ParseFile([]byte(input), "main.tf")
+	if err != nil {
+		t.Fatalf("Failed to parse input: %v", err)
+	}
+
+	s.SortFile(file)
+
+	result := string(p.FormatFile(file))
+	if result != expected {
+		t.Errorf("Sorting failed.\nExpected:\n%s\nGot:\n%s", expected, result)
+	}
+	if err := s.CheckIdempotent(p, []byte(result), "main.tf"); err != nil {
+		t.Errorf("Sorting the result again changed it: %v", err)
 	}
 }
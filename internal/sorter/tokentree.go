@@ -0,0 +1,127 @@
+package sorter
+
+import (
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// tokenNode is a token in the token tree of an expression. Braces, brackets,
+// parentheses, quotes, heredocs and template sequences hold the tokens up to
+// their closing token as children, so that an expression is scanned once
+// however deeply its objects and lists are nested.
+type tokenNode struct {
+	Token    *hclwrite.Token
+	Children []*tokenNode
+	Close    *hclwrite.Token // closing token, nil for a single token
+
+	// newlines counts the newline tokens in the node
+	newlines int
+}
+
+// closingTokens maps the tokens that open a node to the token closing it
+var closingTokens = map[hclsyntax.TokenType]hclsyntax.TokenType{
+	hclsyntax.TokenOBrace:          hclsyntax.TokenCBrace,
+	hclsyntax.TokenOBrack:          hclsyntax.TokenCBrack,
+	hclsyntax.TokenOParen:          hclsyntax.TokenCParen,
+	hclsyntax.TokenOQuote:          hclsyntax.TokenCQuote,
+	hclsyntax.TokenOHeredoc:        hclsyntax.TokenCHeredoc,
+	hclsyntax.TokenTemplateInterp:  hclsyntax.TokenTemplateSeqEnd,
+	hclsyntax.TokenTemplateControl: hclsyntax.TokenTemplateSeqEnd,
+}
+
+// buildTokenTree builds the token tree of an expression in a single pass.
+// Closing tokens without a matching opener are kept as single tokens, and
+// openers that are never closed are followed by their contents.
+func buildTokenTree(tokens hclwrite.Tokens) []*tokenNode {
+	root := &tokenNode{}
+	stack := []*tokenNode{root}
+
+	for _, token := range tokens {
+		top := stack[len(stack)-1]
+		if top != root && token.Type == closingTokens[top.Token.Type] {
+			top.Close = token
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1].newlines += top.newlines
+			continue
+		}
+
+		node := &tokenNode{Token: token}
+		top.Children = append(top.Children, node)
+		if token.Type == hclsyntax.TokenNewline {
+			node.newlines = 1
+			top.newlines++
+		}
+		if _, opens := closingTokens[token.Type]; opens {
+			stack = append(stack, node)
+		}
+	}
+
+	for len(stack) > 1 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		parent := stack[len(stack)-1]
+		parent.Children = append(parent.Children, node.Children...)
+		parent.newlines += node.newlines
+		node.Children, node.newlines = nil, 0
+	}
+
+	return root.Children
+}
+
+// newTokenNode returns a single token node for a token written by the sorter
+func newTokenNode(tokenType hclsyntax.TokenType, bytes string) *tokenNode {
+	node := &tokenNode{Token: &hclwrite.Token{Type: tokenType, Bytes: []byte(bytes)}}
+	if tokenType == hclsyntax.TokenNewline {
+		node.newlines = 1
+	}
+	return node
+}
+
+// tokenNodes returns a single token node for each token
+func tokenNodes(tokens hclwrite.Tokens) []*tokenNode {
+	nodes := make([]*tokenNode, len(tokens))
+	for i, token := range tokens {
+		nodes[i] = &tokenNode{Token: token}
+		if token.Type == hclsyntax.TokenNewline {
+			nodes[i].newlines = 1
+		}
+	}
+	return nodes
+}
+
+// setChildren replaces the children of a node, updating its newline count
+func (n *tokenNode) setChildren(children []*tokenNode) {
+	n.Children = children
+	n.newlines = countNewlines(children)
+}
+
+// countNewlines returns the number of newline tokens in nodes
+func countNewlines(nodes []*tokenNode) int {
+	count := 0
+	for _, node := range nodes {
+		count += node.newlines
+	}
+	return count
+}
+
+// appendTokens appends the tokens of nodes to tokens in order
+func appendTokens(tokens hclwrite.Tokens, nodes []*tokenNode) hclwrite.Tokens {
+	for _, node := range nodes {
+		tokens = append(tokens, node.Token)
+		tokens = appendTokens(tokens, node.Children)
+		if node.Close != nil {
+			tokens = append(tokens, node.Close)
+		}
+	}
+	return tokens
+}
+
+// indexTokenTree maps the opening token of each node with children to its node
+func indexTokenTree(nodes []*tokenNode, index map[*hclwrite.Token]*tokenNode) {
+	for _, node := range nodes {
+		if node.Close != nil {
+			index[node.Token] = node
+			indexTokenTree(node.Children, index)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package sorter
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+func TestBuildTokenTree(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		src      string
+		nodes    int // top-level nodes
+		newlines int // newlines in the first node
+	}{
+		"nested":        {src: "{\n  a = [1, (2)]\n  b = \"${x}\"\n}", nodes: 1, newlines: 3},
+		"heredoc":       {src: "<<EOT\n{\nEOT\n", nodes: 2, newlines: 0},
+		"unclosed":      {src: "{ a = [1 }", nodes: 6, newlines: 0},
+		"stray closing": {src: "a ] b", nodes: 3, newlines: 0},
+	}
+	for name, tt := range tests {
+		tokens := lexTokens(tt.src)
+		nodes := buildTokenTree(tokens)
+
+		if len(nodes) != tt.nodes {
+			t.Errorf("%s: %d top-level nodes, want %d", name, len(nodes), tt.nodes)
+		}
+		if nodes[0].newlines != tt.newlines {
+			t.Errorf("%s: %d newlines in the first node, want %d", name, nodes[0].newlines, tt.newlines)
+		}
+		if actual := string(appendTokens(nil, nodes).Bytes()); actual != tt.src {
+			t.Errorf("%s: tokens = %q, want %q", name, actual, tt.src)
+		}
+	}
+}
+
+// lexTokens returns the tokens of an expression, which need not be valid
+func lexTokens(src string) hclwrite.Tokens {
+	var tokens hclwrite.Tokens
+	syntaxTokens, _ := hclsyntax.LexExpression([]byte(src), "", hcl.InitialPos)
+	for i, token := range syntaxTokens {
+		if token.Type == hclsyntax.TokenEOF {
+			break
+		}
+		spaces := 0
+		if i > 0 {
+			spaces = token.Range.Start.Byte - syntaxTokens[i-1].Range.End.Byte
+		}
+		tokens = append(tokens, &hclwrite.Token{Type: token.Type, Bytes: token.Bytes, SpacesBefore: spaces})
+	}
+	return tokens
+}